@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// readTimeout and writeTimeout limit one request to StreamServer, changing a camera takes longer
+	readTimeout  = 5 * time.Second
+	writeTimeout = 15 * time.Second
+	// readAttempts is the number of tries of a GET request, other requests are sent once
+	readAttempts = 3
+	// retryDelay is the base of exponential backoff between tries, the delay is randomized
+	retryDelay = 300 * time.Millisecond
+	// breakerThreshold is the number of failed calls in a row that opens the breaker
+	breakerThreshold = 3
+	// breakerCooldown is how long calls are rejected before a trial call is let through
+	breakerCooldown = 30 * time.Second
+)
+
+// states of the circuit breaker
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// errBreakerOpen means the call was not sent because StreamServer failed recently
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+// circuitBreaker stops calls to StreamServer after several failures in a row,
+// so commands fail at once instead of waiting for timeouts of a server that is down.
+// After breakerCooldown one trial call is let through, its success closes the breaker.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	lastErr  error
+	probing  bool
+	// now is the clock of the breaker, tests replace it
+	now func() time.Time
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{state: breakerClosed, now: time.Now}
+}
+
+// allow returns errBreakerOpen if the call must not be sent
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < breakerCooldown {
+			return errBreakerOpen
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		// only one trial call at a time
+		if b.probing {
+			return errBreakerOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// success records successful call and closes the breaker.
+// Returns true if the breaker was not closed before.
+func (b *circuitBreaker) success() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	recovered := b.state != breakerClosed
+	b.state = breakerClosed
+	b.failures = 0
+	b.probing = false
+	b.lastErr = nil
+	return recovered
+}
+
+// failure records failed call. Returns true if the breaker has just opened.
+func (b *circuitBreaker) failure(err error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.lastErr = err
+	b.probing = false
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= breakerThreshold) {
+		opened := b.state == breakerClosed
+		b.state = breakerOpen
+		b.openedAt = b.now()
+		return opened
+	}
+	return false
+}
+
+// cancel records call that ended without result, e.g. cut by a deadline.
+// The state is kept, only the trial call slot is freed.
+func (b *circuitBreaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// reset closes the breaker, e.g. after StreamServer is restarted
+func (b *circuitBreaker) reset() {
+	b.success()
+}
+
+// describe reports the breaker state for /status
+func (b *circuitBreaker) describe() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		wait := breakerCooldown - b.now().Sub(b.openedAt)
+		if wait < 0 {
+			return fmt.Sprintf("запросы приостановлены после %d ошибок подряд (%v), пробный запрос будет отправлен при следующей команде", b.failures, b.lastErr)
+		}
+		return fmt.Sprintf("запросы приостановлены после %d ошибок подряд (%v), пробный запрос через %d с", b.failures, b.lastErr, int(wait.Seconds())+1)
+	case breakerHalfOpen:
+		return "выполняется пробный запрос"
+	default:
+		if b.failures > 0 {
+			return fmt.Sprintf("работает, ошибок подряд: %d", b.failures)
+		}
+		return "работает"
+	}
+}
+
+// retryBackoff returns randomized delay before the try after the given one,
+// so concurrent handlers don't retry at the same moments
+func retryBackoff(attempt int) time.Duration {
+	limit := retryDelay << attempt
+	return limit/2 + time.Duration(rand.Int63n(int64(limit/2)+1))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// breakerStep is an action on the breaker and its state after it.
+// Actions are allow, reject (allow refused), fail, succeed, cancel, tick (a second passes)
+// and cooldown (breakerCooldown passes).
+type breakerStep struct {
+	action string
+	state  string
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	opened := []breakerStep{
+		{"allow", breakerClosed}, {"fail", breakerClosed},
+		{"allow", breakerClosed}, {"fail", breakerClosed},
+		{"allow", breakerClosed}, {"fail", breakerOpen},
+	}
+	tests := []struct {
+		name  string
+		steps []breakerStep
+	}{
+		{"failures below threshold keep it closed", []breakerStep{
+			{"fail", breakerClosed}, {"fail", breakerClosed}, {"succeed", breakerClosed},
+			{"fail", breakerClosed}, {"fail", breakerClosed}, {"allow", breakerClosed},
+		}},
+		{"threshold failures open it", append(opened,
+			breakerStep{"reject", breakerOpen}, breakerStep{"tick", breakerOpen}, breakerStep{"reject", breakerOpen},
+		)},
+		{"cooldown lets one trial call through", append(opened,
+			breakerStep{"cooldown", breakerOpen}, breakerStep{"allow", breakerHalfOpen},
+			breakerStep{"reject", breakerHalfOpen}, breakerStep{"reject", breakerHalfOpen},
+		)},
+		{"successful trial call closes it", append(opened,
+			breakerStep{"cooldown", breakerOpen}, breakerStep{"allow", breakerHalfOpen},
+			breakerStep{"succeed", breakerClosed}, breakerStep{"allow", breakerClosed}, breakerStep{"allow", breakerClosed},
+		)},
+		{"failed trial call opens it again", append(opened,
+			breakerStep{"cooldown", breakerOpen}, breakerStep{"allow", breakerHalfOpen},
+			breakerStep{"fail", breakerOpen}, breakerStep{"tick", breakerOpen}, breakerStep{"reject", breakerOpen},
+			breakerStep{"cooldown", breakerOpen}, breakerStep{"allow", breakerHalfOpen},
+		)},
+		{"canceled trial call frees the slot", append(opened,
+			breakerStep{"cooldown", breakerOpen}, breakerStep{"allow", breakerHalfOpen},
+			breakerStep{"cancel", breakerHalfOpen}, breakerStep{"allow", breakerHalfOpen},
+			breakerStep{"reject", breakerHalfOpen}, breakerStep{"succeed", breakerClosed},
+		)},
+		{"cancel keeps closed breaker closed", []breakerStep{
+			{"fail", breakerClosed}, {"cancel", breakerClosed}, {"fail", breakerClosed}, {"fail", breakerOpen},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			b := newCircuitBreaker()
+			b.now = func() time.Time { return clock }
+
+			for i, step := range test.steps {
+				switch step.action {
+				case "allow":
+					if err := b.allow(); err != nil {
+						t.Fatalf("step %d: call rejected: %v", i, err)
+					}
+				case "reject":
+					if err := b.allow(); !errors.Is(err, errBreakerOpen) {
+						t.Fatalf("step %d: allow returned %v, want errBreakerOpen", i, err)
+					}
+				case "fail":
+					b.failure(errors.New("connection refused"))
+				case "succeed":
+					b.success()
+				case "cancel":
+					b.cancel()
+				case "tick":
+					clock = clock.Add(time.Second)
+				case "cooldown":
+					clock = clock.Add(breakerCooldown)
+				}
+				if b.state != step.state {
+					t.Fatalf("step %d %s: state is %s, want %s", i, step.action, b.state, step.state)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerReports(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker()
+	b.now = func() time.Time { return clock }
+
+	if opened := b.failure(errors.New("refused")); opened {
+		t.Error("breaker opened after one failure")
+	}
+	if state := b.describe(); state != "работает, ошибок подряд: 1" {
+		t.Errorf("describe is %q", state)
+	}
+	b.failure(errors.New("refused"))
+	if opened := b.failure(errors.New("refused")); !opened {
+		t.Error("failure didn't report opening")
+	}
+	clock = clock.Add(10*time.Second + 500*time.Millisecond)
+	if state := b.describe(); state != "запросы приостановлены после 3 ошибок подряд (refused), пробный запрос через 20 с" {
+		t.Errorf("describe is %q", state)
+	}
+
+	clock = clock.Add(breakerCooldown)
+	b.allow()
+	if opened := b.failure(errors.New("refused")); opened {
+		t.Error("failed trial call reported opening of an open breaker")
+	}
+	clock = clock.Add(breakerCooldown)
+	b.allow()
+	if recovered := b.success(); !recovered {
+		t.Error("success of trial call didn't report recovery")
+	}
+	if recovered := b.success(); recovered {
+		t.Error("success of closed breaker reported recovery")
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// chatbotWatchInterval is how often running chatbots are checked for crashes
+	chatbotWatchInterval = 30 * time.Second
+	// chatbotCrashLogLines is the number of log lines sent when the chatbot crashes
+	chatbotCrashLogLines = 15
+)
+
+// startChatbot starts the chatbot, it requires running StreamServer
+func (s *StreamServer) startChatbot() error {
+	if !s.lifecycle.TryLock() {
+		return errBusy
+	}
+	defer s.lifecycle.Unlock()
+	return s.startChatbotLocked()
+}
+
+// startChatbotLocked starts the chatbot, s.lifecycle must be held
+func (s *StreamServer) startChatbotLocked() error {
+	if !s.awake() {
+		return errors.New("StreamServer is not running")
+	}
+	if err := s.supervisor.Start(s.chatbotProcess); err != nil {
+		return err
+	}
+	s.setChatbotWanted(true)
+	return nil
+}
+
+// stopChatbot stops the chatbot, its exit is not reported as a crash
+func (s *StreamServer) stopChatbot() error {
+	if !s.lifecycle.TryLock() {
+		return errBusy
+	}
+	defer s.lifecycle.Unlock()
+	return s.stopChatbotLocked()
+}
+
+// stopChatbotLocked stops the chatbot, s.lifecycle must be held
+func (s *StreamServer) stopChatbotLocked() error {
+	s.setChatbotWanted(false)
+	return s.supervisor.Stop(s.chatbotProcess)
+}
+
+// lastExit describes the last exit of the process if the supervisor knows it
+func lastExit(server *StreamServer, process Process) string {
+	reporter, ok := server.supervisor.(ExitReporter)
+	if !ok {
+		return ""
+	}
+	status, ok := reporter.LastExit(process)
+	if !ok {
+		return ""
+	}
+	return status
+}
+
+// chatbotStatus describes state of the chatbot for /chatbot status
+func chatbotStatus(server *StreamServer) string {
+	status := "Чат-бот на сервере " + server.Name + ": "
+
+	running, err := server.supervisor.IsRunning(server.chatbotProcess)
+	switch {
+	case err != nil:
+		server.log.Warn("failed to check chatbot process", "err", err)
+		status += "не удалось проверить состояние: " + redact(err.Error()) + "\n"
+	case running:
+		status += "работает\n"
+	default:
+		status += "остановлен\n"
+	}
+
+	if inspector, ok := server.supervisor.(ServiceInspector); ok && running {
+		if info, err := inspector.ServiceInfo(server.chatbotProcess); err == nil {
+			if info.PID > 0 {
+				status += "PID: " + strconv.Itoa(info.PID) + "\n"
+			}
+			if info.Restarts >= 0 {
+				status += "Перезапусков: " + strconv.Itoa(info.Restarts) + "\n"
+			}
+		}
+	}
+	if !running {
+		if exit := lastExit(server, server.chatbotProcess); exit != "" {
+			status += "Последнее завершение: " + exit + "\n"
+		}
+	}
+	return status
+}
+
+// chatbotCrashed checks whether the chatbot exited without /chatbot stop or /halt.
+// Servers being started or stopped are skipped, their chatbot may be down on purpose.
+func (s *StreamServer) chatbotCrashed() bool {
+	if !s.lifecycle.TryLock() {
+		return false
+	}
+	defer s.lifecycle.Unlock()
+
+	if !s.wantsChatbot() {
+		return false
+	}
+	running, err := s.supervisor.IsRunning(s.chatbotProcess)
+	if err != nil {
+		s.log.Warn("failed to check chatbot process", "err", err)
+		return false
+	}
+	if running {
+		return false
+	}
+	s.setChatbotWanted(false)
+	return true
+}
+
+// checkChatbots reports chatbots that exited without /chatbot stop or /halt
+func checkChatbots(bot Messenger) {
+	for _, server := range servers {
+		if !server.chatbotCrashed() {
+			continue
+		}
+
+		exit := lastExit(server, server.chatbotProcess)
+		server.log.Error("chatbot exited unexpectedly", "exit", exit)
+
+		message := "Чат-бот на сервере " + server.Name + " неожиданно завершился"
+		if exit != "" {
+			message += " (" + exit + ")"
+		}
+		message += ".\n"
+		if output, err := server.supervisor.Logs(server.chatbotProcess, chatbotCrashLogLines); err == nil && strings.TrimSpace(output) != "" {
+			message += "\nПоследние строки журнала:\n" + redact(output)
+		}
+		message += "\nДля запуска выполните /chatbot start"
+
+		notifyAdmins(bot, lastRunes(message, maxMessageLength))
+	}
+}
+
+// watchChatbots checks chatbots every chatbotWatchInterval until ctx is done
+func watchChatbots(ctx context.Context, bot Messenger) {
+	ticker := time.NewTicker(chatbotWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		checkChatbots(bot)
+		// a crashed chatbot is not wanted anymore
+		requestSave()
+	}
+}
+
+// handleChatbot processes /chatbot start|stop|restart|status|logs [lines] command on the server
+func handleChatbot(bot Messenger, message *tgbotapi.Message, server *StreamServer) {
+	fields := strings.Fields(message.CommandArguments())
+	action := "status"
+	if len(fields) > 0 {
+		action = strings.ToLower(fields[0])
+	}
+
+	reply := ""
+	switch action {
+	case "start", "restart":
+		if !server.awake() {
+			reply = "Чат-бот работает только вместе с системой трансляций. Пожалуйста, выполните команду /awake для запуска."
+			break
+		}
+		runAsync(bot, message.Chat.ID, "Запускаю чат-бот на сервере "+server.Name+"...", func() string {
+			if action == "restart" {
+				if err := server.stopChatbot(); errors.Is(err, errBusy) {
+					return busyMessage(server)
+				} else if err != nil {
+					server.log.Warn("failed to stop chatbot", "err", err)
+				}
+			} else if running, _ := server.supervisor.IsRunning(server.chatbotProcess); running {
+				server.setChatbotWanted(true)
+				return "Чат-бот на сервере " + server.Name + " уже работает."
+			}
+			if err := server.startChatbot(); errors.Is(err, errBusy) {
+				return busyMessage(server)
+			} else if err != nil {
+				server.log.Error("failed to start chatbot", "err", err)
+				return "Не удалось запустить чат-бот на сервере " + server.Name + ": " + redact(err.Error())
+			}
+			return "Чат-бот на сервере " + server.Name + " запущен."
+		})
+		return
+
+	case "stop":
+		runAsync(bot, message.Chat.ID, "Останавливаю чат-бот на сервере "+server.Name+"...", func() string {
+			if err := server.stopChatbot(); errors.Is(err, errBusy) {
+				return busyMessage(server)
+			} else if err != nil {
+				server.log.Warn("failed to stop chatbot", "err", err)
+				return "Не удалось остановить чат-бот на сервере " + server.Name + ": " + redact(err.Error())
+			}
+			return "Чат-бот на сервере " + server.Name + " остановлен. Трансляция продолжается."
+		})
+		return
+
+	case "status":
+		reply = chatbotStatus(server)
+
+	case "logs":
+		lines := defaultLogLines
+		if len(fields) > 1 {
+			if value, err := strconv.Atoi(fields[1]); err == nil && value > 0 {
+				lines = min(value, maxLogLines)
+			}
+		}
+		sendLogs(bot, message.Chat.ID, server, server.chatbotProcess, lines)
+		return
+
+	default:
+		reply = "Простите, но такой команды нет. Используйте /chatbot start, stop, restart, status или logs."
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, reply)
+	bot.Send(msg)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	defaultClipDuration = 10
+	maxClipDuration     = 60
+	// maxUploadSize is the limit of files sent by bots through Telegram Bot API
+	maxUploadSize = 50 << 20
+	// clipAudioBitrate is used when the clip is re-encoded, bits per second
+	clipAudioBitrate = 64000
+)
+
+// parseClipArgs splits "/clip [camera] [seconds]" arguments, camera name may contain spaces
+func parseClipArgs(args string) (string, int, error) {
+	fields := strings.Fields(args)
+	seconds := defaultClipDuration
+
+	if len(fields) > 0 {
+		if value, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+			if value < 1 || value > maxClipDuration {
+				return "", 0, fmt.Errorf("длительность клипа должна быть от 1 до %d секунд", maxClipDuration)
+			}
+			seconds = value
+			fields = fields[:len(fields)-1]
+		}
+	}
+	return strings.Join(fields, " "), seconds, nil
+}
+
+// runFFmpeg executes ffmpeg, adding its error output to the error
+func runFFmpeg(ctx context.Context, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", append([]string{"-hide_banner", "-loglevel", "error", "-y"}, args...)...)
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.New("ffmpeg did not finish in time")
+	}
+	if err != nil {
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// recordClip writes MP4 clip of the input to the path.
+// V4L2 devices give raw frames, so they are encoded, RTSP and stream are copied as is.
+func recordClip(input []string, encode bool, seconds int, path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(seconds)*time.Second+30*time.Second)
+	defer cancel()
+
+	args := append([]string{}, input...)
+	args = append(args, "-t", strconv.Itoa(seconds))
+	if encode {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p")
+	} else {
+		args = append(args, "-c", "copy")
+	}
+	args = append(args, "-movflags", "+faststart", path)
+
+	return runFFmpeg(ctx, args...)
+}
+
+// shrinkClip re-encodes clip with bitrate that fits it into maxUploadSize
+func shrinkClip(path string, seconds int, target string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(seconds)*4*time.Second+30*time.Second)
+	defer cancel()
+
+	// 10% are left for the container overhead
+	videoBitrate := maxUploadSize*8/10*9/seconds - clipAudioBitrate
+	if videoBitrate < 100000 {
+		videoBitrate = 100000
+	}
+	bitrate := strconv.Itoa(videoBitrate)
+
+	return runFFmpeg(ctx,
+		"-i", path,
+		"-c:v", "libx264", "-preset", "veryfast",
+		"-b:v", bitrate, "-maxrate", bitrate, "-bufsize", strconv.Itoa(videoBitrate*2),
+		"-vf", "scale=-2:'min(720,ih)'",
+		"-c:a", "aac", "-b:a", strconv.Itoa(clipAudioBitrate),
+		"-movflags", "+faststart", target)
+}
+
+// makeClip records clip into dir and returns path to the file that fits upload limit
+func makeClip(dir string, input []string, encode bool, seconds int) (string, error) {
+	path := filepath.Join(dir, "clip.mp4")
+	if err := recordClip(input, encode, seconds, path); err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() <= maxUploadSize {
+		return path, nil
+	}
+
+	systemLog.Info("clip is too large, re-encoding", "size", info.Size())
+	shrunk := filepath.Join(dir, "clip-small.mp4")
+	if err := shrinkClip(path, seconds, shrunk); err != nil {
+		return "", err
+	}
+
+	info, err = os.Stat(shrunk)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > maxUploadSize {
+		return "", errors.New("clip is too large even after re-encoding")
+	}
+	return shrunk, nil
+}
+
+// handleClip processes /clip [camera] [seconds] command on the server.
+// Without camera name the outgoing stream is recorded.
+func handleClip(bot Messenger, message *tgbotapi.Message, server *StreamServer) {
+	if !server.awake() {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Важно - настройка камер невозможна при выключенной системе. Пожалуйста, выполните команду /awake для запуска.\n")
+		bot.Send(msg)
+		return
+	}
+
+	name, seconds, err := parseClipArgs(message.CommandArguments())
+	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Простите, но "+err.Error()+". Например: /clip Коридор 10")
+		bot.Send(msg)
+		return
+	}
+
+	var input []string
+	encode := false
+	caption := ""
+
+	if name == "" {
+		streamURL := server.getStreamURL()
+		if streamURL == "" {
+			msg := tgbotapi.NewMessage(message.Chat.ID, "Сервер не отвечает, проверьте его состояние.")
+			bot.Send(msg)
+			return
+		}
+		input = []string{"-i", streamURL}
+		caption = "Трансляция"
+	} else {
+		source, ok := server.cameraSource(name)
+		if !ok {
+			msg := tgbotapi.NewMessage(message.Chat.ID, "Строка подключения камеры \""+name+"\" неизвестна. Клип доступен только для камер, добавленных через бота.")
+			bot.Send(msg)
+			return
+		}
+		input = ffmpegInput(source)
+		encode = source.Type == 0
+		caption = name
+	}
+
+	progress := "Записываю клип длительностью " + strconv.Itoa(seconds) + " с..."
+	runAsync(bot, message.Chat.ID, progress, func() string {
+		dir, err := os.MkdirTemp("", "clip")
+		if err != nil {
+			botLog.Error("failed to create temporary directory", "err", err)
+			return "Не удалось записать клип: нет места для временного файла."
+		}
+		defer os.RemoveAll(dir)
+
+		path, err := makeClip(dir, input, encode, seconds)
+		if err != nil {
+			systemLog.Warn("failed to record clip", "camera", caption, "err", err)
+			return "Не удалось записать клип: " + redact(err.Error())
+		}
+
+		video := tgbotapi.NewVideoUpload(message.Chat.ID, path)
+		video.Caption = caption
+		video.Duration = seconds
+		if _, err := bot.Send(video); err != nil {
+			botLog.Error("failed to send clip", "camera", caption, "err", err)
+			return "Не удалось отправить клип."
+		}
+		return "Клип длительностью " + strconv.Itoa(seconds) + " с записан."
+	})
+}
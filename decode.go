@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// schemaError means StreamServer answered with data of unexpected structure
+type schemaError struct {
+	Endpoint string
+	Reason   string
+}
+
+func (e *schemaError) Error() string {
+	return "unexpected response of " + e.Endpoint + ": " + e.Reason
+}
+
+// rejectedError means StreamServer refused the request, Message is the text of its answer
+type rejectedError struct {
+	Endpoint string
+	Status   int
+	Message  string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("%s returned status %d: %s", e.Endpoint, e.Status, e.Message)
+}
+
+// newRejectedError keeps the beginning of the answer, the server may send a whole page
+func newRejectedError(endpoint string, status int, payload []byte) *rejectedError {
+	message := strings.TrimSpace(string(payload))
+	if runes := []rune(message); len(runes) > 300 {
+		message = string(runes[:300]) + "..."
+	}
+	return &rejectedError{Endpoint: endpoint, Status: status, Message: message}
+}
+
+// notAppliedError means StreamServer accepted the change, but it wasn't seen before the timeout
+type notAppliedError struct {
+	// Change is what was expected, e.g. `camera "Коридор" is active`
+	Change string
+	// Actual is what the server reported last
+	Actual string
+}
+
+func (e *notAppliedError) Error() string {
+	return "change is not applied: expected " + e.Change + ", got " + e.Actual
+}
+
+// camerasArrays is the format of /get-cameras with parallel arrays of names and types.
+// Types are JSON numbers, StreamServer may send them as 1.0.
+type camerasArrays struct {
+	Names *[]string  `json:"names"`
+	Types *[]float64 `json:"types"`
+}
+
+// cameraEntry is an element of /get-cameras in the object list format
+type cameraEntry struct {
+	Name *string  `json:"name"`
+	Type *float64 `json:"type"`
+	URL  string   `json:"url"`
+}
+
+// cameraType converts JSON number of the camera type, it must be a whole number
+func cameraType(endpoint string, name string, value float64) (int, error) {
+	if value != math.Trunc(value) || math.Abs(value) > math.MaxInt32 {
+		return 0, &schemaError{Endpoint: endpoint, Reason: fmt.Sprintf("camera %q has type %v instead of integer", name, value)}
+	}
+	return int(value), nil
+}
+
+// describeJSONError explains decoding error in terms of the payload
+func describeJSONError(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		if typeErr.Field != "" {
+			return fmt.Sprintf("field %q has %s instead of %s", typeErr.Field, typeErr.Value, typeErr.Type)
+		}
+		return fmt.Sprintf("got %s instead of %s", typeErr.Value, typeErr.Type)
+	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return fmt.Sprintf("malformed JSON at offset %d: %v", syntaxErr.Offset, err)
+	}
+	return err.Error()
+}
+
+// decodeCameras parses /get-cameras response in either format:
+// {"names": [...], "types": [...]} or [{"name": ..., "type": ..., "url": ...}].
+// URL is known only in the object list format.
+func decodeCameras(payload []byte) ([]AddCameraData, error) {
+	const endpoint = "/get-cameras"
+	payload = bytes.TrimSpace(payload)
+	if len(payload) == 0 {
+		return nil, &schemaError{Endpoint: endpoint, Reason: "empty body"}
+	}
+
+	var cameras []AddCameraData
+	switch payload[0] {
+	case '[':
+		var entries []cameraEntry
+		if err := json.Unmarshal(payload, &entries); err != nil {
+			return nil, &schemaError{Endpoint: endpoint, Reason: describeJSONError(err)}
+		}
+		for i, entry := range entries {
+			if entry.Name == nil || entry.Type == nil {
+				return nil, &schemaError{Endpoint: endpoint, Reason: fmt.Sprintf("camera %d has no name or type", i)}
+			}
+			cameraType, err := cameraType(endpoint, *entry.Name, *entry.Type)
+			if err != nil {
+				return nil, err
+			}
+			cameras = append(cameras, AddCameraData{Name: *entry.Name, Type: cameraType, URL: entry.URL})
+		}
+
+	case '{':
+		var arrays camerasArrays
+		if err := json.Unmarshal(payload, &arrays); err != nil {
+			return nil, &schemaError{Endpoint: endpoint, Reason: describeJSONError(err)}
+		}
+		if arrays.Names == nil || arrays.Types == nil {
+			return nil, &schemaError{Endpoint: endpoint, Reason: "names or types are missing"}
+		}
+		names, types := *arrays.Names, *arrays.Types
+		if len(names) != len(types) {
+			return nil, &schemaError{Endpoint: endpoint, Reason: fmt.Sprintf("%d names for %d types", len(names), len(types))}
+		}
+		for i := range names {
+			cameraType, err := cameraType(endpoint, names[i], types[i])
+			if err != nil {
+				return nil, err
+			}
+			cameras = append(cameras, AddCameraData{Name: names[i], Type: cameraType})
+		}
+
+	default:
+		return nil, &schemaError{Endpoint: endpoint, Reason: "expected JSON object or array"}
+	}
+
+	seen := map[string]bool{}
+	for _, camera := range cameras {
+		if camera.Name == "" {
+			return nil, &schemaError{Endpoint: endpoint, Reason: "camera with empty name"}
+		}
+		if camera.Type < 0 {
+			return nil, &schemaError{Endpoint: endpoint, Reason: fmt.Sprintf("camera %q has negative type %d", camera.Name, camera.Type)}
+		}
+		if seen[camera.Name] {
+			return nil, &schemaError{Endpoint: endpoint, Reason: fmt.Sprintf("camera %q is listed twice", camera.Name)}
+		}
+		seen[camera.Name] = true
+	}
+	return cameras, nil
+}
+
+// decodeActive parses /get-active response {"name": ..., "type": ...}
+func decodeActive(payload []byte) (CameraData, error) {
+	const endpoint = "/get-active"
+
+	var entry cameraEntry
+	if err := json.Unmarshal(bytes.TrimSpace(payload), &entry); err != nil {
+		return CameraData{}, &schemaError{Endpoint: endpoint, Reason: describeJSONError(err)}
+	}
+	if entry.Name == nil || entry.Type == nil {
+		return CameraData{}, &schemaError{Endpoint: endpoint, Reason: "name or type is missing"}
+	}
+	cameraType, err := cameraType(endpoint, *entry.Name, *entry.Type)
+	if err != nil {
+		return CameraData{}, err
+	}
+	return CameraData{Name: *entry.Name, Type: cameraType}, nil
+}
+
+// serverError describes failed StreamServer request for the user
+func serverError(err error) string {
+	var schemaErr *schemaError
+	var rejectedErr *rejectedError
+	switch {
+	case errors.As(err, &rejectedErr):
+		message := "StreamServer отклонил запрос (код " + strconv.Itoa(rejectedErr.Status) + ")"
+		if rejectedErr.Message != "" {
+			message += ": " + redact(rejectedErr.Message)
+		}
+		return message + "."
+	case errors.As(err, &schemaErr):
+		return "StreamServer вернул ответ в неизвестном формате (" + schemaErr.Endpoint + ": " + schemaErr.Reason + "). Возможно, версии бота и сервера не совместимы."
+	case errors.Is(err, errBreakerOpen):
+		return "Сервер недавно не отвечал несколько раз подряд, запросы к нему временно приостановлены. Проверьте его состояние командой /status."
+	case errors.Is(err, fasthttp.ErrTimeout):
+		return "Сервер не ответил вовремя, проверьте его состояние."
+	}
+	return "Сервер не отвечает, проверьте его состояние."
+}
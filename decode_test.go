@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCameras(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    []AddCameraData
+		err     string
+	}{
+		{"arrays", `{"names": ["Коридор", "Вебка"], "types": [1, 0]}`,
+			[]AddCameraData{{Name: "Коридор", Type: 1}, {Name: "Вебка", Type: 0}}, ""},
+		{"arrays with float types", `{"names": ["Коридор"], "types": [2.0]}`,
+			[]AddCameraData{{Name: "Коридор", Type: 2}}, ""},
+		{"object list", ` [{"name": "Улица", "type": 2, "url": "rtsp://10.0.0.2/stream"}, {"name": "Вебка", "type": 0.0}] `,
+			[]AddCameraData{{Name: "Улица", Type: 2, URL: "rtsp://10.0.0.2/stream"}, {Name: "Вебка", Type: 0}}, ""},
+		{"empty lists", `{"names": [], "types": []}`, nil, ""},
+		{"empty body", "  ", nil, "empty body"},
+		{"null", `null`, nil, "expected JSON object or array"},
+		{"malformed", `{"names": ["broken", 1], "types": [`, nil, "malformed JSON"},
+		{"wrong element type", `{"names": ["Коридор"], "types": ["1"]}`, nil, "has string instead of float64"},
+		{"missing types", `{"names": ["Коридор"]}`, nil, "names or types are missing"},
+		{"length mismatch", `{"names": ["Коридор", "Вебка"], "types": [1]}`, nil, "2 names for 1 types"},
+		{"fractional type", `{"names": ["Коридор"], "types": [1.5]}`, nil, `camera "Коридор" has type 1.5 instead of integer`},
+		{"huge type", `[{"name": "Коридор", "type": 1e20}]`, nil, `camera "Коридор" has type 1e+20 instead of integer`},
+		{"entry without type", `[{"name": "Коридор"}]`, nil, "camera 0 has no name or type"},
+		{"empty name", `{"names": ["Коридор", ""], "types": [1, 1]}`, nil, "camera with empty name"},
+		{"negative type", `[{"name": "Коридор", "type": -1}]`, nil, `camera "Коридор" has negative type -1`},
+		{"duplicate name", `{"names": ["Коридор", "Коридор"], "types": [1, 2]}`, nil, `camera "Коридор" is listed twice`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cameras, err := decodeCameras([]byte(test.payload))
+			if test.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(cameras, test.want) {
+					t.Errorf("cameras are %+v, want %+v", cameras, test.want)
+				}
+				return
+			}
+
+			var schemaErr *schemaError
+			if !errors.As(err, &schemaErr) || schemaErr.Endpoint != "/get-cameras" {
+				t.Fatalf("error is %v, want schema error of /get-cameras", err)
+			}
+			if !strings.Contains(schemaErr.Reason, test.err) {
+				t.Errorf("reason is %q, want %q", schemaErr.Reason, test.err)
+			}
+		})
+	}
+}
+
+func TestDecodeActive(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    CameraData
+		err     string
+	}{
+		{`{"name": "Коридор", "type": 1}`, CameraData{Name: "Коридор", Type: 1}, ""},
+		{`{"name": "Коридор", "type": 1.0}`, CameraData{Name: "Коридор", Type: 1}, ""},
+		{`{"name": "Коридор"}`, CameraData{}, "name or type is missing"},
+		{`{"name": "Коридор", "type": 0.5}`, CameraData{}, "instead of integer"},
+		{`["Коридор"]`, CameraData{}, "instead of"},
+	}
+
+	for _, test := range tests {
+		camera, err := decodeActive([]byte(test.payload))
+		if test.err == "" {
+			if err != nil || camera != test.want {
+				t.Errorf("%s: got %+v, %v, want %+v", test.payload, camera, err, test.want)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error is %v, want %q", test.payload, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// dockerTimeout limits one Docker Engine API call
+	dockerTimeout = 30 * time.Second
+	// dockerPullTimeout limits downloading of a missing image
+	dockerPullTimeout = 10 * time.Minute
+	// dockerStopTimeout is given to a container to exit before it is killed, seconds
+	dockerStopTimeout = 10
+)
+
+// DockerConfig describes containers of the broadcast stack
+type DockerConfig struct {
+	// Socket is the Docker Engine socket, /var/run/docker.sock by default
+	Socket string `json:"socket"`
+	// Network is the network mode of the containers, "host" by default
+	// so StreamServer is reachable on its configured address
+	Network string          `json:"network"`
+	Server  ContainerConfig `json:"server"`
+	Chatbot ContainerConfig `json:"chatbot"`
+}
+
+// ContainerConfig describes one container
+type ContainerConfig struct {
+	Image string `json:"image"`
+	// Tag is the image tag, "latest" by default
+	Tag string `json:"tag"`
+	// Devices are passed into the container, patterns like /dev/video* are expanded on start
+	Devices []string `json:"devices"`
+	Env     []string `json:"env"`
+}
+
+// dockerSupervisor runs processes as containers through Docker Engine API
+type dockerSupervisor struct {
+	server     string
+	network    string
+	containers map[string]ContainerConfig
+	client     *http.Client
+}
+
+// dockerError is an error response of Docker Engine API
+type dockerError struct {
+	Status  int
+	Message string `json:"message"`
+}
+
+func (e *dockerError) Error() string {
+	return fmt.Sprintf("docker: %d %s", e.Status, e.Message)
+}
+
+// isDockerStatus checks that err is Docker Engine API response with the status
+func isDockerStatus(err error, status int) bool {
+	var apiErr *dockerError
+	return errors.As(err, &apiErr) && apiErr.Status == status
+}
+
+// newDockerSupervisor prepares client of Docker Engine API on the unix socket
+func newDockerSupervisor(config ServerConfig) (*dockerSupervisor, error) {
+	if config.Docker == nil || config.Docker.Server.Image == "" || config.Docker.Chatbot.Image == "" {
+		return nil, errors.New("docker backend requires server and chatbot images")
+	}
+
+	socket := config.Docker.Socket
+	if socket == "" {
+		socket = "/var/run/docker.sock"
+	}
+	network := config.Docker.Network
+	if network == "" {
+		network = "host"
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}}
+
+	return &dockerSupervisor{
+		server:  config.Name,
+		network: network,
+		containers: map[string]ContainerConfig{
+			streamProcessName:  config.Docker.Server,
+			chatbotProcessName: config.Docker.Chatbot},
+		client: &http.Client{Transport: transport}}, nil
+}
+
+// dockerNameChars are characters allowed in container names after the first one
+var dockerNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// containerName returns name of the container running the process. Docker allows only
+// [a-zA-Z0-9][a-zA-Z0-9_.-]* in names, so other characters of the server name are replaced
+// and a hash of the original name keeps e.g. Cyrillic names of different servers apart.
+func (d *dockerSupervisor) containerName(process Process) string {
+	server := dockerNameChars.ReplaceAllString(d.server, "_")
+	if server != d.server {
+		hash := fnv.New32a()
+		hash.Write([]byte(d.server))
+		server += fmt.Sprintf("-%08x", hash.Sum32())
+	}
+	return "streamadmin-" + server + "-" + strings.ToLower(process.Name)
+}
+
+// containerPath returns path of the container of the process in Docker Engine API
+func (d *dockerSupervisor) containerPath(process Process) string {
+	return "/containers/" + url.PathEscape(d.containerName(process))
+}
+
+// call sends request to Docker Engine API, error responses are returned as *dockerError.
+// Response body must be closed by the caller.
+func (d *dockerSupervisor) call(ctx context.Context, method string, path string, body any) (*http.Response, error) {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		payload = bytes.NewReader(data)
+	}
+
+	// host is ignored, connection always goes to the socket
+	request, err := http.NewRequestWithContext(ctx, method, "http://docker"+path, payload)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 300 {
+		defer response.Body.Close()
+		apiErr := &dockerError{Status: response.StatusCode}
+		json.NewDecoder(response.Body).Decode(apiErr)
+		return nil, apiErr
+	}
+	return response, nil
+}
+
+// do sends request and discards response body
+func (d *dockerSupervisor) do(method string, path string, body any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+
+	response, err := d.call(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// pull downloads the image, progress is streamed as JSON objects with possible error
+func (d *dockerSupervisor) pull(image string, tag string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPullTimeout)
+	defer cancel()
+
+	query := url.Values{"fromImage": {image}, "tag": {tag}}
+	response, err := d.call(ctx, http.MethodPost, "/images/create?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var progress struct {
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&progress); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if progress.Error != "" {
+			return errors.New(progress.Error)
+		}
+	}
+}
+
+// expandDevices resolves device patterns, missing devices are skipped
+func expandDevices(patterns []string) []map[string]string {
+	var devices []map[string]string
+	for _, pattern := range patterns {
+		paths, _ := filepath.Glob(pattern)
+		for _, path := range paths {
+			devices = append(devices, map[string]string{
+				"PathOnHost":        path,
+				"PathInContainer":   path,
+				"CgroupPermissions": "rwm"})
+		}
+	}
+	return devices
+}
+
+// Start recreates the container, so changes of the configuration and new devices are applied
+func (d *dockerSupervisor) Start(process Process) error {
+	config, ok := d.containers[process.Name]
+	if !ok {
+		return fmt.Errorf("no container configured for %s", process.Name)
+	}
+	tag := config.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	name, path := d.containerName(process), d.containerPath(process)
+
+	err := d.do(http.MethodDelete, path+"?force=true", nil)
+	if err != nil && !isDockerStatus(err, http.StatusNotFound) {
+		return err
+	}
+
+	create := map[string]any{
+		"Image":  config.Image + ":" + tag,
+		"Env":    config.Env,
+		"Labels": map[string]string{"streamadminbot.server": d.server},
+		"HostConfig": map[string]any{
+			"NetworkMode":   d.network,
+			"Devices":       expandDevices(config.Devices),
+			"RestartPolicy": map[string]any{"Name": "on-failure", "MaximumRetryCount": 5}}}
+
+	err = d.do(http.MethodPost, "/containers/create?name="+url.QueryEscape(name), create)
+	if isDockerStatus(err, http.StatusNotFound) {
+		systemLog.Info("pulling image", "image", config.Image, "tag", tag)
+		if err := d.pull(config.Image, tag); err != nil {
+			return fmt.Errorf("failed to pull %s:%s: %v", config.Image, tag, err)
+		}
+		err = d.do(http.MethodPost, "/containers/create?name="+url.QueryEscape(name), create)
+	}
+	if err != nil {
+		return err
+	}
+
+	return d.do(http.MethodPost, path+"/start", nil)
+}
+
+// Stop stops the container, it is kept until the next start so its logs remain available
+func (d *dockerSupervisor) Stop(process Process) error {
+	err := d.do(http.MethodPost, d.containerPath(process)+"/stop?t="+strconv.Itoa(dockerStopTimeout), nil)
+	if isDockerStatus(err, http.StatusNotModified) || isDockerStatus(err, http.StatusNotFound) {
+		return nil
+	}
+	return err
+}
+
+// containerState is a part of the container inspection
+type containerState struct {
+	RestartCount int `json:"RestartCount"`
+	State        struct {
+		Status    string    `json:"Status"`
+		Running   bool      `json:"Running"`
+		Pid       int       `json:"Pid"`
+		ExitCode  int       `json:"ExitCode"`
+		StartedAt time.Time `json:"StartedAt"`
+		Health    *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+}
+
+// inspect returns state of the container
+func (d *dockerSupervisor) inspect(process Process) (containerState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+
+	var state containerState
+	response, err := d.call(ctx, http.MethodGet, d.containerPath(process)+"/json", nil)
+	if err != nil {
+		return state, err
+	}
+	defer response.Body.Close()
+
+	err = json.NewDecoder(response.Body).Decode(&state)
+	return state, err
+}
+
+func (d *dockerSupervisor) IsRunning(process Process) (bool, error) {
+	state, err := d.inspect(process)
+	if isDockerStatus(err, http.StatusNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return state.State.Running, nil
+}
+
+// ServiceInfo reports container status with health check result if the image defines one
+func (d *dockerSupervisor) ServiceInfo(process Process) (ServiceInfo, error) {
+	info := ServiceInfo{Unit: d.containerName(process)}
+
+	state, err := d.inspect(process)
+	if isDockerStatus(err, http.StatusNotFound) {
+		info.State = "missing"
+		return info, nil
+	}
+	if err != nil {
+		return info, err
+	}
+
+	info.State = state.State.Status
+	switch {
+	case state.State.Health != nil:
+		info.SubState = state.State.Health.Status
+	case state.State.Running:
+		info.SubState = "no healthcheck"
+	default:
+		info.SubState = "exit code " + strconv.Itoa(state.State.ExitCode)
+	}
+	info.PID = state.State.Pid
+	info.Restarts = state.RestartCount
+	if !state.State.StartedAt.IsZero() {
+		info.Since = state.State.StartedAt.Local()
+	}
+	return info, nil
+}
+
+// LastExit reports exit code of the stopped container
+func (d *dockerSupervisor) LastExit(process Process) (string, bool) {
+	state, err := d.inspect(process)
+	if err != nil || state.State.Running || state.State.StartedAt.IsZero() {
+		return "", false
+	}
+	return "exit status " + strconv.Itoa(state.State.ExitCode), true
+}
+
+// Logs reads container output. Containers without TTY multiplex stdout and stderr
+// into frames with 8 byte header: stream type, 3 zero bytes and big endian frame size.
+func (d *dockerSupervisor) Logs(process Process, lines int) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+
+	query := url.Values{"stdout": {"true"}, "stderr": {"true"}, "tail": {strconv.Itoa(lines)}}
+	response, err := d.call(ctx, http.MethodGet, d.containerPath(process)+"/logs?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	var output bytes.Buffer
+	reader := bufio.NewReader(response.Body)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(reader, header); err == io.EOF {
+			return output.String(), nil
+		} else if err != nil {
+			return "", err
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(&output, reader, size); err != nil {
+			return "", err
+		}
+	}
+}
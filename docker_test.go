@@ -0,0 +1,33 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestContainerName(t *testing.T) {
+	valid := regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+	process := Process{Name: streamProcessName}
+
+	d := &dockerSupervisor{server: "lab"}
+	if name := d.containerName(process); name != "streamadmin-lab-streamserver" {
+		t.Errorf("container of lab is %q", name)
+	}
+
+	seen := map[string]string{}
+	for _, server := range []string{"lab", "lab 1", "lab_1", "лаборатория 1", "лаборатория 2", "кабинет 1", "a/b?c"} {
+		d := &dockerSupervisor{server: server}
+		name := d.containerName(process)
+		if !valid.MatchString(name) {
+			t.Errorf("container of %q is %q, not a valid Docker name", server, name)
+		}
+		if other, ok := seen[name]; ok {
+			t.Errorf("servers %q and %q share container %q", server, other, name)
+		}
+		seen[name] = server
+		if path := d.containerPath(process); path != "/containers/"+name || strings.ContainsAny(path[len("/containers/"):], "/? ") {
+			t.Errorf("path of %q is %q", server, path)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failure modes of the fake StreamServer endpoints
+const (
+	fakeOK        = "ok"
+	fakeError     = "error"
+	fakeMalformed = "malformed"
+	fakeEmpty     = "empty"
+	// fakeSlow answers as usual after fakeDelay
+	fakeSlow = "slow"
+)
+
+// fakeDelay is the delay of answers in fakeSlow mode
+const fakeDelay = 2 * time.Second
+
+// fakeStreamServer emulates StreamServer API on httptest server and records every call,
+// fakeSupervisor starts and stops its processes
+type fakeStreamServer struct {
+	server *httptest.Server
+
+	mu      sync.Mutex
+	running map[string]bool
+	cameras []AddCameraData
+	active  string
+	modes   map[string]string
+	// calls are taken by tests, journal is shown by /logs
+	calls   []string
+	journal []string
+}
+
+func newFakeStreamServer(t *testing.T) *fakeStreamServer {
+	f := &fakeStreamServer{
+		running: map[string]bool{},
+		modes:   map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/get-cameras", f.getCameras)
+	mux.HandleFunc("/get-active", f.getActive)
+	mux.HandleFunc("/stream-url", f.streamURL)
+	mux.HandleFunc("/add-camera", f.addCamera)
+	mux.HandleFunc("/select-camera", f.selectCamera)
+
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// address returns host:port of the fake for ServerConfig.Address
+func (f *fakeStreamServer) address() string {
+	return strings.TrimPrefix(f.server.URL, "http://")
+}
+
+// record adds the call to the journal
+func (f *fakeStreamServer) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+	f.journal = append(f.journal, call)
+}
+
+// takeCalls returns calls recorded since the previous take
+func (f *fakeStreamServer) takeCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+// setMode switches the endpoint to a failure mode, fakeOK restores it
+func (f *fakeStreamServer) setMode(endpoint string, mode string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.modes[endpoint] = mode
+}
+
+// addExisting lists the camera as if it was added before the bot
+func (f *fakeStreamServer) addExisting(camera AddCameraData) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cameras = append(f.cameras, camera)
+}
+
+// fail records the request and answers it according to the failure mode,
+// returns false and the request body in ok mode
+func (f *fakeStreamServer) fail(w http.ResponseWriter, r *http.Request) (bool, []byte) {
+	body, _ := io.ReadAll(r.Body)
+	call := r.Method + " " + r.URL.Path
+	if len(body) > 0 {
+		call += " " + string(body)
+	}
+	f.record(call)
+
+	f.mu.Lock()
+	mode := f.modes[r.URL.Path]
+	f.mu.Unlock()
+
+	switch mode {
+	case fakeError:
+		http.Error(w, "fake internal error", http.StatusInternalServerError)
+	case fakeMalformed:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"names": ["broken", 1], "types": [`))
+	case fakeEmpty:
+		w.WriteHeader(http.StatusNoContent)
+	case fakeSlow:
+		time.Sleep(fakeDelay)
+		return false, body
+	default:
+		return false, body
+	}
+	return true, nil
+}
+
+// writeJSON sends value as JSON response
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func (f *fakeStreamServer) getCameras(w http.ResponseWriter, r *http.Request) {
+	if failed, _ := f.fail(w, r); failed {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.cameras) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	names := []string{}
+	types := []int{}
+	for _, camera := range f.cameras {
+		names = append(names, camera.Name)
+		types = append(types, camera.Type)
+	}
+	writeJSON(w, map[string]any{"names": names, "types": types})
+}
+
+func (f *fakeStreamServer) getActive(w http.ResponseWriter, r *http.Request) {
+	if failed, _ := f.fail(w, r); failed {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, camera := range f.cameras {
+		if camera.Name == f.active {
+			writeJSON(w, map[string]any{"name": camera.Name, "type": camera.Type})
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeStreamServer) streamURL(w http.ResponseWriter, r *http.Request) {
+	if failed, _ := f.fail(w, r); failed {
+		return
+	}
+	w.Write([]byte("rtmp://" + f.address() + "/live/fake"))
+}
+
+func (f *fakeStreamServer) addCamera(w http.ResponseWriter, r *http.Request) {
+	failed, body := f.fail(w, r)
+	if failed {
+		return
+	}
+
+	var camera AddCameraData
+	if err := json.Unmarshal(body, &camera); err != nil || camera.Name == "" {
+		http.Error(w, "bad camera data", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, existing := range f.cameras {
+		if existing.Name == camera.Name {
+			http.Error(w, "camera exists", http.StatusBadRequest)
+			return
+		}
+	}
+	f.cameras = append(f.cameras, camera)
+}
+
+func (f *fakeStreamServer) selectCamera(w http.ResponseWriter, r *http.Request) {
+	failed, body := f.fail(w, r)
+	if failed {
+		return
+	}
+
+	var selected SelectCameraJSON
+	if err := json.Unmarshal(body, &selected); err != nil {
+		http.Error(w, "bad camera name", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, camera := range f.cameras {
+		if camera.Name == selected.CameraName {
+			f.active = camera.Name
+			return
+		}
+	}
+	http.Error(w, "unknown camera", http.StatusBadRequest)
+}
+
+// fakeSupervisor marks processes of the fake as running, the API is served all the time
+type fakeSupervisor struct {
+	fake *fakeStreamServer
+}
+
+func (s *fakeSupervisor) Start(process Process) error {
+	s.fake.record("start " + process.Name)
+	s.fake.mu.Lock()
+	defer s.fake.mu.Unlock()
+	s.fake.running[process.Name] = true
+	return nil
+}
+
+func (s *fakeSupervisor) Stop(process Process) error {
+	s.fake.record("stop " + process.Name)
+	s.fake.mu.Lock()
+	defer s.fake.mu.Unlock()
+	s.fake.running[process.Name] = false
+	return nil
+}
+
+func (s *fakeSupervisor) IsRunning(process Process) (bool, error) {
+	s.fake.mu.Lock()
+	defer s.fake.mu.Unlock()
+	return s.fake.running[process.Name], nil
+}
+
+// Logs returns the journal of calls
+func (s *fakeSupervisor) Logs(process Process, lines int) (string, error) {
+	s.fake.mu.Lock()
+	defer s.fake.mu.Unlock()
+	journal := s.fake.journal
+	if len(journal) > lines {
+		journal = journal[len(journal)-lines:]
+	}
+	return strings.Join(journal, "\n"), nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cpuSampleInterval is the time between two readings of /proc/stat used to calculate CPU usage
+const cpuSampleInterval = 500 * time.Millisecond
+
+// HostMetrics describes resources of the machine running the bot
+type HostMetrics struct {
+	// CPU is the usage of all cores in percent
+	CPU   float64
+	Load1 float64
+	Cores int
+
+	MemTotal     uint64
+	MemAvailable uint64
+
+	// DiskPath is the directory whose file system is measured
+	DiskPath  string
+	DiskTotal uint64
+	DiskFree  uint64
+
+	// Temperature is the hottest thermal zone in degrees Celsius, zero when unknown
+	Temperature float64
+}
+
+// cpuTimes reads total and idle time of all cores from /proc/stat
+func cpuTimes() (total uint64, idle uint64, err error) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return 0, 0, errors.New("/proc/stat is empty")
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, errors.New("unexpected format of /proc/stat")
+	}
+
+	// user nice system idle iowait irq softirq steal, idle and iowait are idle time
+	for i, field := range fields[1:] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		if i >= 8 {
+			// guest time is already counted in user time
+			break
+		}
+		total += value
+		if i == 3 || i == 4 {
+			idle += value
+		}
+	}
+	return total, idle, nil
+}
+
+// cpuUsage measures CPU usage in percent over interval
+func cpuUsage(interval time.Duration) (float64, error) {
+	total1, idle1, err := cpuTimes()
+	if err != nil {
+		return 0, err
+	}
+	time.Sleep(interval)
+	total2, idle2, err := cpuTimes()
+	if err != nil {
+		return 0, err
+	}
+	if total2 <= total1 {
+		return 0, nil
+	}
+	return 100 * float64((total2-total1)-(idle2-idle1)) / float64(total2-total1), nil
+}
+
+// loadAverage reads one minute load average from /proc/loadavg
+func loadAverage() (float64, error) {
+	payload, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(payload))
+	if len(fields) == 0 {
+		return 0, errors.New("/proc/loadavg is empty")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// memoryInfo reads total and available memory from /proc/meminfo in bytes
+func memoryInfo() (total uint64, available uint64, err error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		// values are in kB
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = value * 1024
+		case "MemAvailable:":
+			available = value * 1024
+		}
+	}
+	if total == 0 {
+		return 0, 0, errors.New("MemTotal not found in /proc/meminfo")
+	}
+	return total, available, scanner.Err()
+}
+
+// diskUsage returns size and free space of the file system containing path
+func diskUsage(path string) (total uint64, free uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	return stat.Blocks * uint64(stat.Bsize), stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// cpuTemperature returns the highest temperature of thermal zones in /sys
+func cpuTemperature() (float64, error) {
+	zones, _ := filepath.Glob("/sys/class/thermal/thermal_zone*/temp")
+	found := false
+	highest := 0.0
+	for _, zone := range zones {
+		payload, err := os.ReadFile(zone)
+		if err != nil {
+			continue
+		}
+		// values are in millidegrees
+		value, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+		if err != nil {
+			continue
+		}
+		if !found || value/1000 > highest {
+			highest = value / 1000
+			found = true
+		}
+	}
+	if !found {
+		return 0, errors.New("no thermal zones found")
+	}
+	return highest, nil
+}
+
+// collectHostMetrics reads host resources, unavailable metrics are left zero
+func collectHostMetrics(diskPath string) HostMetrics {
+	metrics := HostMetrics{DiskPath: diskPath}
+
+	var err error
+	if metrics.CPU, err = cpuUsage(cpuSampleInterval); err != nil {
+		systemLog.Debug("failed to read CPU usage", "err", err)
+	}
+	if metrics.Load1, err = loadAverage(); err != nil {
+		systemLog.Debug("failed to read load average", "err", err)
+	}
+	metrics.Cores = runtime.NumCPU()
+	if metrics.MemTotal, metrics.MemAvailable, err = memoryInfo(); err != nil {
+		systemLog.Debug("failed to read memory info", "err", err)
+	}
+	if metrics.DiskTotal, metrics.DiskFree, err = diskUsage(diskPath); err != nil {
+		systemLog.Debug("failed to read disk usage", "path", diskPath, "err", err)
+	}
+	if metrics.Temperature, err = cpuTemperature(); err != nil {
+		systemLog.Debug("failed to read temperature", "err", err)
+	}
+	return metrics
+}
+
+// formatBytes prints size in human readable units
+func formatBytes(size uint64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f ГБ", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f МБ", float64(size)/(1<<20))
+	default:
+		return fmt.Sprintf("%.1f КБ", float64(size)/(1<<10))
+	}
+}
+
+// describeHost formats host metrics for /status
+func describeHost(metrics HostMetrics) string {
+	report := fmt.Sprintf("CPU: %.0f%%, нагрузка %.2f", metrics.CPU, metrics.Load1)
+	if metrics.Cores > 0 {
+		report += fmt.Sprintf(" на %d ядер", metrics.Cores)
+	}
+	report += "\n"
+	if metrics.MemTotal > 0 {
+		used := metrics.MemTotal - metrics.MemAvailable
+		report += fmt.Sprintf("Память: %s из %s (%.0f%%)\n", formatBytes(used), formatBytes(metrics.MemTotal), 100*float64(used)/float64(metrics.MemTotal))
+	}
+	if metrics.DiskTotal > 0 {
+		report += fmt.Sprintf("Диск %s: свободно %s из %s\n", metrics.DiskPath, formatBytes(metrics.DiskFree), formatBytes(metrics.DiskTotal))
+	}
+	if metrics.Temperature > 0 {
+		report += fmt.Sprintf("Температура: %.0f °C\n", metrics.Temperature)
+	}
+	return report
+}
+
+// interfaceCounters are received and transmitted bytes of a network interface
+type interfaceCounters struct {
+	Received    uint64
+	Transmitted uint64
+}
+
+// networkCounters reads byte counters of network interfaces from /proc/net/dev, loopback is skipped
+func networkCounters() (map[string]interfaceCounters, error) {
+	file, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	counters := map[string]interfaceCounters{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// two header lines don't contain a colon after the interface name
+		name, data, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		fields := strings.Fields(data)
+		if name == "lo" || len(fields) < 9 {
+			continue
+		}
+		// receive: bytes packets errs drop fifo frame compressed multicast, then transmit bytes
+		received, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		transmitted, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			continue
+		}
+		counters[name] = interfaceCounters{Received: received, Transmitted: transmitted}
+	}
+	return counters, scanner.Err()
+}
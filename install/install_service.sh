@@ -0,0 +1,36 @@
+#!/bin/bash
+# Generates systemd unit of the bot and enables it.
+# The bot reports readiness with sd_notify and feeds the watchdog every WatchdogSec/2.
+
+cd ..
+WORKDIR=$(pwd)
+USER_NAME=${SUDO_USER:-$(whoami)}
+UNIT=/etc/systemd/system/StreamAdminBot.service
+
+sudo tee $UNIT > /dev/null <<UNIT
+[Unit]
+Description=Telegram bot administrating StreamServer
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=notify
+NotifyAccess=main
+User=$USER_NAME
+WorkingDirectory=$WORKDIR
+ExecStart=$WORKDIR/StreamAdminBot
+EnvironmentFile=-$WORKDIR/bot.env
+Restart=on-failure
+RestartSec=5
+WatchdogSec=60
+TimeoutStartSec=120
+TimeoutStopSec=30
+
+[Install]
+WantedBy=multi-user.target
+UNIT
+
+sudo systemctl daemon-reload
+sudo systemctl enable --now StreamAdminBot.service
+
+cd install
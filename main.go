@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"reflect"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
@@ -22,12 +26,15 @@ type State int
 
 // Status.
 const (
-	StateWork         State = 1
-	StateSelectCamera State = 2
-	StateSelectPreset State = 3
-	StateEnterType    State = 4
-	StateEnterURL     State = 5
-	StateEnterName    State = 6
+	StateWork          State = 1
+	StateSelectCamera  State = 2
+	StateSelectPreset  State = 3
+	StateEnterType     State = 4
+	StateEnterURL      State = 5
+	StateEnterName     State = 6
+	StateConfirmCamera State = 7
+	StateImportFile    State = 8
+	StateConfirmImport State = 9
 )
 
 // CameraData discribes generic data
@@ -49,52 +56,80 @@ type SelectCameraJSON struct {
 }
 
 var (
-	adminID    [1]int = [1]int{634596120}
-	isAwake    bool   = false
-	client     *fasthttp.Client
-	request    *fasthttp.Request
-	response   *fasthttp.Response
-	serverIP   string
-	serverPort string
+	adminID [1]int = [1]int{634596120}
 
-	currentState State = StateWork
-
-	cameras []CameraData
 	presets []AddCameraData
-
-	newCamera AddCameraData
 )
 
-func haltSystem() {
-	isAwake = false
+func (s *StreamServer) haltSystem() error {
+	if !s.lifecycle.TryLock() {
+		return errBusy
+	}
+	defer s.lifecycle.Unlock()
+
+	s.setAwake(false)
+
+	s.log.Info("halting system")
 
-	cmdKill := exec.Command("killall", "StreamServer")
-	cmdKill.Run()
+	s.recorder.Stop()
 
-	cmdKill = exec.Command("killall", "/home/anton/Radium/LabYoutubeChatbot/LabYoutubeChatbot")
-	cmdKill.Run()
+	// both processes are stopped even if one of them fails
+	streamErr := s.supervisor.Stop(s.streamProcess)
+	if streamErr != nil {
+		s.log.Warn("failed to stop StreamServer", "err", streamErr)
+		streamErr = fmt.Errorf("StreamServer: %w", streamErr)
+	}
+
+	s.setChatbotWanted(false)
+	chatbotErr := s.supervisor.Stop(s.chatbotProcess)
+	if chatbotErr != nil {
+		s.log.Warn("failed to stop chatbot", "err", chatbotErr)
+		chatbotErr = fmt.Errorf("чат-бот: %w", chatbotErr)
+	}
+	return errors.Join(streamErr, chatbotErr)
 }
 
-func awakeSystem() {
-	if !isAwake {
-		client = &fasthttp.Client{}
-		request = fasthttp.AcquireRequest()
-		response = fasthttp.AcquireResponse()
-		serverPort = ":8081"
-		serverIP = "localhost"
+// setupClient prepares HTTP client for StreamServer
+func (s *StreamServer) setupClient() {
+	s.client = &fasthttp.Client{}
+	s.breaker = newCircuitBreaker()
+}
 
-		cmdRunServer := exec.Command("/home/anton/Radium/StreamServer/StreamServer")
-		cmdRunServer.Start()
+// awakeSystem starts StreamServer, waits until it accepts connections and starts the chatbot if requested
+func (s *StreamServer) awakeSystem(withChatbot bool) error {
+	if !s.lifecycle.TryLock() {
+		return errBusy
+	}
+	defer s.lifecycle.Unlock()
 
-		time.Sleep(3 * time.Second)
+	if s.awake() {
+		return nil
+	}
 
-		cmdRunChatbot := exec.Command("/home/anton/Radium/LabYoutubeChatbot/LabYoutubeChatbot")
-		cmdRunChatbot.Start()
+	s.log.Info("awaking system")
 
-		time.Sleep(6 * time.Second)
+	if err := s.supervisor.Start(s.streamProcess); err != nil {
+		s.log.Error("failed to start StreamServer", "err", err)
+		return err
+	}
 
-		isAwake = true
+	if err := s.waitReady(readyTimeout); err != nil {
+		s.log.Error("StreamServer is not ready", "err", err)
+		return err
 	}
+
+	// failures of the previous run don't matter for the new process
+	s.breaker.reset()
+	s.setAwake(true)
+
+	if withChatbot {
+		if err := s.supervisor.Start(s.chatbotProcess); err != nil {
+			s.log.Error("failed to start chatbot", "err", err)
+		} else {
+			s.setChatbotWanted(true)
+		}
+	}
+	return nil
 }
 
 func isAdmin(id int) bool {
@@ -106,8 +141,8 @@ func isAdmin(id int) bool {
 	return false
 }
 
-func isNameUnique(name string) bool {
-	for _, item := range cameras {
+func (s *StreamServer) isNameUnique(name string) bool {
+	for _, item := range s.cachedCameras() {
 		if item.Name == name {
 			return false
 		}
@@ -120,14 +155,30 @@ func helpMessage() string {
 	message += "Важно - настройка камер невозможна при выключенной системе. Пожалуйста, выполните команду /awake для запуска.\n\n"
 	message += "Введите одну из команд:\n\n"
 	message += "Настройка камер\n"
-	message += "/getcameras - получить список камер\n"
+	message += "/getcameras [tag:тег] - получить список камер, все или с тегом\n"
+	message += "/camera номер|имя [поле значение] - описание, место, теги и ответственный камеры\n"
 	message += "/getactive - посмотреть текущую выбранную камеру\n"
 	message += "/selectcamera - выбрать камеру\n"
 	message += "/addcamera - добавить новую камеру\n"
-	message += "/addpreset - добавить готовую камеру\n\n"
+	message += "/addpreset - добавить готовую камеру\n"
+	message += "/snapshot [камера] - снимок с активной или указанной камеры\n"
+	message += "/clip [камера] [секунды] - видеоклип с камеры или трансляции\n"
+	message += "/record start|stop|status - запись трансляции в архив\n"
+	message += "/recordings [дата] [ЧЧ:ММ-ЧЧ:ММ] [camera:имя] - поиск записей в архиве\n"
+	message += "/cut [дата] ЧЧ:ММ-ЧЧ:ММ [файл.mp4] - вырезать интервал из архива в чат или в файл\n"
+	message += "/scene [имя] - список сцен или применение сцены: камера, запись и чат-бот разом\n"
+	message += "/export [json|yaml] - выгрузить камеры и их описания в файл\n"
+	message += "/import - загрузить камеры из файла /export с подтверждением изменений\n"
+	message += "/preview on|off - показывать предпросмотр перед переключением камеры\n\n"
 	message += "Общее\n"
-	message += "/awake - запустить систему трансляций\n"
-	message += "/halt - выключить систему трансляций\n"
+	message += "/server [имя] - выбрать сервер трансляций\n"
+	message += "/status - состояние бота, хоста, процессов и всех серверов\n"
+	message += "/logs [streamserver|chatbot] [строки] - журнал процесса на выбранном сервере\n"
+	message += "/services - состояние процессов выбранного сервера и число перезапусков\n"
+	message += "/chatbot start|stop|restart|status|logs - управление чат-ботом на выбранном сервере\n"
+	message += "/awake - запустить систему трансляций на выбранном сервере\n"
+	message += "/awake nochatbot - запустить трансляцию без чат-бота\n"
+	message += "/halt - выключить систему трансляций на выбранном сервере\n"
 	message += "/help - помощь по командам\n"
 	return message
 }
@@ -146,168 +197,254 @@ func setupPresets() {
 	presets = append(presets, webcam)
 }
 
-// getCameras receives list of all available cameras from Stream Server
-func getCameras() ([]CameraData, error) {
-	request.Header.SetMethod("GET")
-
-	url := "http://" + serverIP + serverPort + "/get-cameras"
-
-	request.SetRequestURI(url)
-	err := client.Do(request, response)
-	log.Printf("Status code: %d\n", response.StatusCode())
+// call sends request to StreamServer and returns status code with a copy of the body.
+// Request and response are acquired for each call, so the client is safe for concurrent use.
+// GET requests are retried on network errors and 5xx answers, all calls are rejected
+// at once while the circuit breaker is open.
+func (s *StreamServer) call(method string, endpoint string, body []byte) (int, []byte, error) {
+	return s.callUntil(method, endpoint, body, time.Time{})
+}
 
-	if response.StatusCode() == 204 || response.StatusCode() == 400 {
-		return nil, nil
+// callUntil is call that must finish by the deadline, it is sent once and its timeout
+// is cut to the deadline. Zero deadline means usual timeouts and retries.
+// Running out of time is not a failure of StreamServer, so the breaker doesn't count it.
+func (s *StreamServer) callUntil(method string, endpoint string, body []byte, deadline time.Time) (int, []byte, error) {
+	timeout, attempts := writeTimeout, 1
+	if method == "GET" {
+		timeout, attempts = readTimeout, readAttempts
+	}
+	cut := false
+	if !deadline.IsZero() {
+		attempts = 1
+		if left := time.Until(deadline); left < timeout {
+			timeout, cut = left, true
+		}
+		if timeout <= 0 {
+			return 0, nil, fasthttp.ErrTimeout
+		}
 	}
 
-	if err != nil {
-		fmt.Println("Client: GetCameras failed to make a request.")
-		return nil, err
+	if err := s.breaker.allow(); err != nil {
+		s.log.Debug("request rejected", "endpoint", endpoint, "err", err)
+		return 0, nil, err
 	}
 
-	payload := response.Body()
-	var dataJSON map[string]interface{}
+	request := fasthttp.AcquireRequest()
+	response := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(request)
+	defer fasthttp.ReleaseResponse(response)
 
-	if err := json.Unmarshal(payload, &dataJSON); err != nil {
-		fmt.Println("Client: Server returned bad data for GetCameras")
-		return nil, err
+	request.Header.SetMethod(method)
+	request.SetRequestURI("http://" + s.Address + endpoint)
+	if body != nil {
+		request.Header.SetContentType("application/json")
+		request.SetBody(body)
 	}
 
-	var types []interface{}
-	var names []interface{}
-	var cameras []CameraData
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryBackoff(attempt - 1))
+		}
 
-	types = dataJSON["types"].([]interface{})
-	names = dataJSON["names"].([]interface{})
+		err = s.client.DoTimeout(request, response, timeout)
+		if err == nil && response.StatusCode() >= 500 {
+			err = newRejectedError(endpoint, response.StatusCode(), response.Body())
+		}
+		if err == nil {
+			break
+		}
+		s.log.Warn("request failed", "endpoint", endpoint, "attempt", attempt+1, "err", err)
+	}
 
-	for i := 0; i < len(types); i++ {
-		current := CameraData{
-			Name: names[i].(string),
-			Type: int(types[i].(float64))}
-		cameras = append(cameras, current)
+	if cut && errors.Is(err, fasthttp.ErrTimeout) {
+		s.breaker.cancel()
+		return 0, nil, err
+	}
+	if err != nil {
+		if s.breaker.failure(err) {
+			s.log.Error("circuit breaker opened", "endpoint", endpoint, "err", err)
+		}
+		return 0, nil, err
 	}
+	if s.breaker.success() {
+		s.log.Info("circuit breaker closed", "endpoint", endpoint)
+	}
+	s.log.Debug("response received", "endpoint", endpoint, "status", response.StatusCode())
 
-	return cameras, nil
+	return response.StatusCode(), append([]byte(nil), response.Body()...), nil
 }
 
-// getActive gets one active (broadcasting) camera at this moment
-func getActive() (CameraData, error) {
-	request.Header.SetMethod("GET")
+// getCameras receives list of all available cameras from Stream Server
+func (s *StreamServer) getCameras() ([]CameraData, error) {
+	return s.getCamerasUntil(time.Time{})
+}
 
-	url := "http://" + serverIP + serverPort + "/get-active"
+// getCamerasUntil is getCameras bounded by the deadline as callUntil
+func (s *StreamServer) getCamerasUntil(deadline time.Time) ([]CameraData, error) {
+	status, payload, err := s.callUntil("GET", "/get-cameras", nil, deadline)
+	if err != nil {
+		return nil, err
+	}
+	if status == 204 || status == 400 {
+		return nil, nil
+	}
 
-	request.SetRequestURI(url)
-	err := client.Do(request, response)
-	log.Printf("Status code: %d\n", response.StatusCode())
+	cameras, err := decodeCameras(payload)
+	if err != nil {
+		s.log.Error("server returned bad data", "endpoint", "/get-cameras", "err", err, "body", lastRunes(string(payload), 500))
+		return nil, err
+	}
 
-	if response.StatusCode() == 204 || response.StatusCode() == 400 {
-		return CameraData{}, nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []CameraData
+	for _, camera := range cameras {
+		result = append(result, CameraData{Name: camera.Name, Type: camera.Type})
+		// the object list format reports connection strings, so snapshots work for all cameras
+		if _, ok := s.sources[camera.Name]; !ok && camera.URL != "" {
+			s.sources[camera.Name] = camera
+		}
 	}
+	s.syncMetadata(cameras)
+	return result, nil
+}
 
+// getActive gets one active (broadcasting) camera at this moment
+func (s *StreamServer) getActive() (CameraData, error) {
+	return s.getActiveUntil(time.Time{})
+}
+
+// getActiveUntil is getActive bounded by the deadline as callUntil
+func (s *StreamServer) getActiveUntil(deadline time.Time) (CameraData, error) {
+	status, payload, err := s.callUntil("GET", "/get-active", nil, deadline)
 	if err != nil {
-		fmt.Println("Client: GetActive failed to make a request.")
 		return CameraData{}, err
 	}
+	if status == 204 || status == 400 {
+		return CameraData{}, nil
+	}
 
-	payload := response.Body()
-	var dataJSON map[string]interface{}
-
-	if err := json.Unmarshal(payload, &dataJSON); err != nil {
-		fmt.Println("Client: Server returned bad data for GetActive")
+	cam, err := decodeActive(payload)
+	if err != nil {
+		s.log.Error("server returned bad data", "endpoint", "/get-active", "err", err, "body", lastRunes(string(payload), 500))
 		return CameraData{}, err
 	}
-
-	typeCam := int(dataJSON["type"].(float64))
-	nameCam := dataJSON["name"].(string)
-
-	return CameraData{
-		Name: nameCam,
-		Type: typeCam}, nil
+	return cam, nil
 }
 
-func getStreamURL() string {
-	request.Header.SetMethod("GET")
-
-	url := "http://" + serverIP + serverPort + "/stream-url"
-
-	request.SetRequestURI(url)
-	err := client.Do(request, response)
-	log.Printf("Status code: %d\n", response.StatusCode())
-
+func (s *StreamServer) getStreamURL() string {
+	_, payload, err := s.call("GET", "/stream-url", nil)
 	if err != nil {
-		fmt.Println("Client: getStreamURL failed to make a request.")
 		return ""
 	}
-
-	return string(response.Body())
+	return string(payload)
 }
 
-func addCamera(data AddCameraData) error {
-	request.Header.SetMethod("POST")
-	request.Header.SetContentType("application/json")
-
-	url := "http://" + serverIP + serverPort + "/add-camera"
-	request.SetRequestURI(url)
-
+// addCamera creates the camera and waits until StreamServer lists it
+func (s *StreamServer) addCamera(data AddCameraData) error {
 	payload, _ := json.Marshal(data)
 
-	request.SetBody(payload)
-
-	err := client.Do(request, response)
-	log.Printf("Status code: %d\n", response.StatusCode())
-
+	status, answer, err := s.call("POST", "/add-camera", payload)
 	if err != nil {
 		return err
 	}
-	return nil
-}
+	if status < 200 || status > 299 {
+		return newRejectedError("/add-camera", status, answer)
+	}
 
-// selectCamera makes specified camera active, switching the broadcast
-func selectCamera(name string) error {
-	request.Header.SetMethod("POST")
-	request.Header.SetContentType("application/json")
+	s.mu.Lock()
+	s.sources[data.Name] = data
+	s.mu.Unlock()
 
-	url := "http://" + serverIP + serverPort + "/select-camera"
-	request.SetRequestURI(url)
+	return s.waitCameraListed(data.Name)
+}
 
+// selectCamera makes specified camera active, switching the broadcast,
+// and waits until StreamServer reports it as active
+func (s *StreamServer) selectCamera(name string) error {
 	toEncode := &SelectCameraJSON{
 		CameraName: name}
 
 	payload, _ := json.Marshal(toEncode)
 
-	request.SetBody(payload)
-
-	err := client.Do(request, response)
-	log.Printf("Status code: %d\n", response.StatusCode())
-
+	status, answer, err := s.call("POST", "/select-camera", payload)
 	if err != nil {
 		return err
 	}
+	if status < 200 || status > 299 {
+		return newRejectedError("/select-camera", status, answer)
+	}
+
+	if err := s.waitCameraActive(name); err != nil {
+		return err
+	}
+	s.recorder.CameraChanged(name)
 	return nil
 }
 
 func main() {
-	newCamera = AddCameraData{}
+	if err := setupLogging(); err != nil {
+		botLog.Warn("invalid logging configuration", "err", err)
+	}
+
 	setupPresets()
 
+	if err := setupRecorder(); err != nil {
+		systemLog.Warn("invalid recorder configuration", "err", err)
+	}
+
+	if value := os.Getenv("CONFIG_FILE"); value != "" {
+		configFile = value
+	}
+	config, err := loadConfig()
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := setupServers(config); err != nil {
+		log.Panic(err)
+	}
+	if err := setupMonitor(config.Monitor); err != nil {
+		log.Panic(err)
+	}
+
+	if value := os.Getenv("UPDATE_WORKERS"); value != "" {
+		workers, err := strconv.Atoi(value)
+		if err != nil || workers < 1 {
+			botLog.Warn("invalid UPDATE_WORKERS, using default", "value", value, "default", updateWorkers)
+		} else {
+			updateWorkers = workers
+		}
+	}
+
+	if value := os.Getenv("STATE_FILE"); value != "" {
+		stateFile = value
+	}
+	saved, err := loadState()
+	if err != nil {
+		botLog.Error("failed to load saved state", "err", err)
+	}
+	recovery := reconcileState(saved)
+
 	socks5 := os.Getenv("SOCKS5_PROXY")
 	client := &http.Client{}
 
 	if len(socks5) > 0 {
-		log.Printf("Found SOCKS5 PROXY: %s\n", socks5)
+		botLog.Info("found SOCKS5 proxy", "url", socks5)
 		tgProxyURL, err := url.Parse(socks5)
 		if err != nil {
-			log.Printf("Failed to parse proxy URL:%s\n", err)
+			botLog.Error("failed to parse proxy URL", "err", err)
 			log.Panic(err)
 		}
 		tgDialer, err := proxy.FromURL(tgProxyURL, proxy.Direct)
 		if err != nil {
-			log.Printf("Failed to obtain proxy dialer: %s\n", err)
+			botLog.Error("failed to obtain proxy dialer", "err", err)
 		}
 		tgTransport := &http.Transport{
 			Dial: tgDialer.Dial,
 		}
 		client.Transport = tgTransport
+		downloadClient.Transport = tgTransport
 	}
 
 	bot, err := tgbotapi.NewBotAPIWithClient("", tgbotapi.APIEndpoint, client)
@@ -317,382 +454,559 @@ func main() {
 
 	bot.Debug = false
 
-	log.Printf("Authorized on account %s", bot.Self.UserName)
+	botLog.Info("authorized on account", "username", bot.Self.UserName)
+	notifyAdmins(bot, recovery)
 
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
 	updates, err := bot.GetUpdatesChan(u)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	for update := range updates {
-		if update.Message == nil {
-			continue
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-		if reflect.TypeOf(update.Message.Text).Kind() == reflect.String && update.Message.Text != "" {
-			log.Printf("[%d] %s", update.Message.From.ID, update.Message.Text)
-			log.Printf("Current state: %d", int(currentState))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runUpdates(ctx, bot, updates)
+	}()
 
-			if !isAdmin(update.Message.From.ID) {
-				log.Println("Unauthorized connection to the chatbot")
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Вы не авторизованы.\nПожалуйста, свяжитесь с администратором, чтобы получить права доступа: anton.fedyashov@gmail.com.")
-				bot.Send(msg)
-				continue
+	go runMonitor(ctx, bot)
+	go watchChatbots(ctx, bot)
+	go runStateSaver(ctx)
+	notifySystemdReady(ctx)
+
+	<-ctx.Done()
+	stop()
+	notifySystemdStopping()
+	shutdown(bot, done)
+}
+
+// heartbeatInterval is how often the idle update loop reports that it is alive
+const heartbeatInterval = time.Second
+
+// runUpdates dispatches incoming updates until ctx is cancelled, then waits for queued updates
+func runUpdates(ctx context.Context, bot Messenger, updates tgbotapi.UpdatesChannel) {
+	workers := newDispatcher(bot, updateWorkers)
+	defer workers.wait()
+
+	// the heartbeat feeds systemd watchdog while the loop is able to take updates
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	markUpdateLoopAlive()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			markUpdateLoopAlive()
+		case update, ok := <-updates:
+			if !ok {
+				return
 			}
+			workers.dispatch(update)
+			markUpdateLoopAlive()
+		}
+	}
+}
 
-			switch currentState {
-			case StateWork:
-				switch update.Message.Text {
-				case "/start":
-					message := "Привет! Я могу управлять системой онлайн-трансляций.\n"
-					message += helpMessage()
+// handleUpdate dispatches one update and requests saving of changed state, a panic affects only this update
+func handleUpdate(bot Messenger, update tgbotapi.Update) {
+	if update.CallbackQuery == nil && update.Message == nil {
+		return
+	}
+	dispatchUpdate(bot, update)
+	requestSave()
+}
 
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-					bot.Send(msg)
-					currentState = StateWork
+// dispatchUpdate calls the handler of the update with the session of its chat locked
+func dispatchUpdate(bot Messenger, update tgbotapi.Update) {
+	var session *Session
+	if chatID, _, _ := updateSource(update); chatID != 0 {
+		session = getSession(chatID)
+		session.mu.Lock()
+		defer session.mu.Unlock()
+	}
+	defer recoverUpdate(bot, update, session)
 
-				case "/help":
-					message := helpMessage()
+	if update.CallbackQuery != nil {
+		handleCallback(bot, update.CallbackQuery)
+	} else {
+		handleMessage(bot, update)
+	}
+}
 
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-					bot.Send(msg)
-					currentState = StateWork
+// handleCallback processes presses of inline keyboard buttons
+func handleCallback(bot Messenger, query *tgbotapi.CallbackQuery) {
+	reqLog := botLog.With("user_id", query.From.ID, "callback", query.Data)
+	reqLog.Info("callback received")
 
-				case "/awake":
-					awakeSystem()
-					URL := getStreamURL()
-					message := "Система запущена. URL онлайн-трансляции: " + URL
+	if !isAdmin(query.From.ID) || query.Message == nil {
+		reqLog.Warn("unauthorized callback")
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, "Вы не авторизованы."))
+		return
+	}
 
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-					bot.Send(msg)
-					currentState = StateWork
+	session := getSession(query.Message.Chat.ID)
+
+	switch {
+	case query.Data == callbackConfirmCamera || query.Data == callbackCancelCamera:
+		handlePreviewAnswer(bot, query, session)
+	case strings.HasPrefix(query.Data, "rec:"):
+		handleRecordingsCallback(bot, query, session)
+	case query.Data == callbackApplyImport || query.Data == callbackCancelImport:
+		handleImportAnswer(bot, query, session)
+	default:
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, ""))
+	}
+}
 
-				case "/halt":
-					haltSystem()
-					message := "Система остановлена."
+// handleMessage processes message of the update according to the state of the chat session
+func handleMessage(bot Messenger, update tgbotapi.Update) {
+	hasText := reflect.TypeOf(update.Message.Text).Kind() == reflect.String && update.Message.Text != ""
+	if hasText || update.Message.Document != nil {
+		session := getSession(update.Message.Chat.ID)
+		server := sessionServer(session)
+		reqLog := messageLogger(update.Message, session)
+		reqLog.Info("message received", "text", update.Message.Text)
+
+		if !isAdmin(update.Message.From.ID) {
+			reqLog.Warn("unauthorized connection to the chatbot")
+			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Вы не авторизованы.\nПожалуйста, свяжитесь с администратором, чтобы получить права доступа: anton.fedyashov@gmail.com.")
+			bot.Send(msg)
+			return
+		}
 
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-					bot.Send(msg)
-					currentState = StateWork
+		// files are expected only by /import
+		if update.Message.Document != nil && session.State != StateImportFile {
+			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Файлы принимаются только после команды /import.")
+			bot.Send(msg)
+			return
+		}
 
-				case "/getcameras":
-					if !isAwake {
-						message := "Важно - настройка камер невозможна при выключенной системе. Пожалуйста, выполните команду /awake для запуска.\n"
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-						bot.Send(msg)
-					} else {
-						var err error
-						cameras, err = getCameras()
-						if err != nil {
-							message := "Сервер не отвечает, проверьте его состояние."
-							msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-							bot.Send(msg)
-							currentState = StateWork
-							continue
-						}
+		switch session.State {
+		case StateWork:
+			switch update.Message.Command() {
+			case "start":
+				message := "Привет! Я могу управлять системой онлайн-трансляций.\n"
+				message += helpMessage()
 
-						message := ""
-
-						if len(cameras) != 0 {
-							message = "Список доступных камер:\n"
-							for i := 0; i < len(cameras); i++ {
-								data := strconv.Itoa(i+1) + ") " + cameras[i].Name + " ("
-								if cameras[i].Type == 1 || cameras[i].Type == 2 {
-									data += "RTSP)"
-								} else {
-									data += "Webcam)"
-								}
-								message += data + "\n"
-							}
-							message += "\n"
-						} else {
-							message = "Сейчас нет доступных камер. Вы можете выбрать готовую камеру /addpreset или создать новую с нуля /addcamera."
-						}
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+				bot.Send(msg)
+				session.State = StateWork
 
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-						bot.Send(msg)
-						currentState = StateWork
+			case "help":
+				message := helpMessage()
+
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+				bot.Send(msg)
+				session.State = StateWork
+
+			case "awake":
+				withChatbot := strings.TrimSpace(update.Message.CommandArguments()) != "nochatbot"
+				runAsync(bot, update.Message.Chat.ID, "Запускаю систему на сервере "+server.Name+"...", func() string {
+					if err := server.awakeSystem(withChatbot); errors.Is(err, errBusy) {
+						return busyMessage(server)
+					} else if err != nil {
+						return "Не удалось запустить систему на сервере " + server.Name + ": " + redact(err.Error()) + processHealth(server)
 					}
+					URL := server.getStreamURL()
+					return "Система на сервере " + server.Name + " запущена. URL онлайн-трансляции: " + URL + processHealth(server)
+				})
+				session.State = StateWork
+
+			case "halt":
+				runAsync(bot, update.Message.Chat.ID, "Останавливаю систему на сервере "+server.Name+"...", func() string {
+					if err := server.haltSystem(); errors.Is(err, errBusy) {
+						return busyMessage(server)
+					} else if err != nil {
+						return "Не удалось остановить систему на сервере " + server.Name + ": " + redact(err.Error()) + processHealth(server)
+					}
+					return "Система на сервере " + server.Name + " остановлена." + processHealth(server)
+				})
+				session.State = StateWork
 
-				case "/getactive":
-					if !isAwake {
-						message := "Важно - настройка камер невозможна при выключенной системе. Пожалуйста, выполните команду /awake для запуска.\n"
+			case "getcameras":
+				if !server.awake() {
+					message := "Важно - настройка камер невозможна при выключенной системе. Пожалуйста, выполните команду /awake для запуска.\n"
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+					bot.Send(msg)
+				} else {
+					cameras, err := server.getCameras()
+					if err != nil {
+						message := serverError(err)
 						msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
 						bot.Send(msg)
-					} else {
-						cam, err := getActive()
-						if err != nil {
-							message := "Сервер не отвечает, проверьте его состояние."
-							msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-							bot.Send(msg)
-							currentState = StateWork
-							continue
-						}
+						session.State = StateWork
+						return
+					}
+					server.setCameras(cameras)
 
-						message := ""
+					message := ""
+					tag := parseTagFilter(update.Message.CommandArguments())
+					list, shown := cameraList(server, cameras, tag)
 
-						if cam.Name != "" {
-							message = "Камера, с которой ведется трансляция:\n"
-							message += cam.Name + " ("
-							if cam.Type == 1 || cam.Type == 2 {
-								message += "RTSP)"
-							} else {
-								message += "Webcam)"
-							}
-						} else {
-							message = "Сейчас ни одна камера не работает. Вы можете выбрать готовую камеру /addpreset или создать новую с нуля /addcamera."
+					if shown != 0 {
+						message = "Список доступных камер:\n" + list + "\n"
+						if tags := knownTags(server); tag == "" && len(tags) > 0 {
+							message += "Фильтр по тегу: /getcameras tag:" + tags[0] + "\n"
 						}
+					} else if tag != "" {
+						message = "Нет камер с тегом #" + tag + ". Теги задаются командой /camera."
+					} else {
+						message = "Сейчас нет доступных камер. Вы можете выбрать готовую камеру /addpreset или создать новую с нуля /addcamera."
+					}
 
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+					bot.Send(msg)
+					session.State = StateWork
+				}
+
+			case "getactive":
+				if !server.awake() {
+					message := "Важно - настройка камер невозможна при выключенной системе. Пожалуйста, выполните команду /awake для запуска.\n"
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+					bot.Send(msg)
+				} else {
+					cam, err := server.getActive()
+					if err != nil {
+						message := serverError(err)
 						msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
 						bot.Send(msg)
-						currentState = StateWork
+						session.State = StateWork
+						return
 					}
 
-				case "/selectcamera":
-					if !isAwake {
-						message := "Важно - настройка камер невозможна при выключенной системе. Пожалуйста, выполните команду /awake для запуска.\n"
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-						bot.Send(msg)
-					} else {
-						var err error
-						cameras, err = getCameras()
-						if err != nil {
-							message := "Сервер не отвечает, проверьте его состояние."
-							msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-							bot.Send(msg)
-							currentState = StateWork
-							continue
-						}
+					message := ""
 
-						message := ""
-
-						if len(cameras) != 0 {
-							message = "Список доступных камер:\n"
-
-							for i := 0; i < len(cameras); i++ {
-								data := strconv.Itoa(i+1) + ") " + cameras[i].Name + " ("
-								if cameras[i].Type == 1 || cameras[i].Type == 2 {
-									data += "RTSP)"
-								} else {
-									data += "Webcam)"
-								}
-								message += data + "\n"
-							}
-							message += "\n"
-							message += "Сделайте выбор, введя номер камеры в списке, например, 1 или 2. Для отмены введите /cancel."
-							currentState = StateSelectCamera
+					if cam.Name != "" {
+						message = "Камера, с которой ведется трансляция:\n"
+						message += cam.Name + " ("
+						if cam.Type == 1 || cam.Type == 2 {
+							message += "RTSP)"
 						} else {
-							message = "Сейчас нет доступных камер. Вы можете выбрать готовую камеру /addpreset или создать новую с нуля /addcamera."
-							currentState = StateWork
+							message += "Webcam)"
 						}
-
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-						bot.Send(msg)
-					}
-
-				case "/addcamera":
-					if !isAwake {
-						message := "Важно - настройка камер невозможна при выключенной системе. Пожалуйста, выполните команду /awake для запуска.\n"
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-						bot.Send(msg)
 					} else {
-						newCamera.Name = ""
-						newCamera.Type = -1
-						newCamera.URL = ""
+						message = "Сейчас ни одна камера не работает. Вы можете выбрать готовую камеру /addpreset или создать новую с нуля /addcamera."
+					}
 
-						message := "Введите уникальное имя новой камеры:"
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+					bot.Send(msg)
+					session.State = StateWork
+				}
 
+			case "selectcamera":
+				if !server.awake() {
+					message := "Важно - настройка камер невозможна при выключенной системе. Пожалуйста, выполните команду /awake для запуска.\n"
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+					bot.Send(msg)
+				} else {
+					cameras, err := server.getCameras()
+					if err != nil {
+						message := serverError(err)
 						msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
 						bot.Send(msg)
-						currentState = StateEnterName
+						session.State = StateWork
+						return
 					}
+					server.setCameras(cameras)
 
-				case "/addpreset":
-					if !isAwake {
-						message := "Важно - настройка камер невозможна при выключенной системе. Пожалуйста, выполните команду /awake для запуска.\n"
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-						bot.Send(msg)
-					} else {
-						newCamera.Name = ""
-						newCamera.Type = -1
-						newCamera.URL = ""
-
-						message := "Список доступных пресетов:\n"
-
-						for i := 0; i < len(presets); i++ {
-							data := strconv.Itoa(i+1) + ") " + presets[i].Name + " ("
-							if presets[i].Type == 1 || presets[i].Type == 2 {
-								data += "RTSP)"
-							} else {
-								data += "Webcam)"
-							}
-							message += data + "\n"
-						}
-						message += "\n"
-						message += "Сделайте выбор, введя номер камеры в списке, например, 1 или 2. Для отмены введите /cancel."
+					message := ""
+					tag := parseTagFilter(update.Message.CommandArguments())
+					list, shown := cameraList(server, cameras, tag)
 
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-						bot.Send(msg)
-						currentState = StateSelectPreset
+					if shown != 0 {
+						message = "Список доступных камер:\n" + list + "\n"
+						message += "Сделайте выбор, введя номер камеры в списке, например, 1 или 2. Для отмены введите /cancel."
+						session.State = StateSelectCamera
+					} else if tag != "" {
+						message = "Нет камер с тегом #" + tag + ". Теги задаются командой /camera."
+						session.State = StateWork
+					} else {
+						message = "Сейчас нет доступных камер. Вы можете выбрать готовую камеру /addpreset или создать новую с нуля /addcamera."
+						session.State = StateWork
 					}
+
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+					bot.Send(msg)
 				}
 
-			case StateSelectCamera:
-				if update.Message.Text == "/cancel" {
-					message := "Выбор камеры отменен. Введите следующую команду."
+			case "addcamera":
+				if !server.awake() {
+					message := "Важно - настройка камер невозможна при выключенной системе. Пожалуйста, выполните команду /awake для запуска.\n"
 					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
 					bot.Send(msg)
-					currentState = StateWork
 				} else {
-					if value, err := strconv.ParseInt(update.Message.Text, 10, 64); err == nil {
-						if value < 1 || value > int64(len(cameras)) {
-							message := "Простите, но камеры с таким номером не существует. Введите другой номер или /cancel."
-							msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-							bot.Send(msg)
-							currentState = StateSelectCamera
-							continue
-						}
+					session.NewCamera.Name = ""
+					session.NewCamera.Type = -1
+					session.NewCamera.URL = ""
 
-						selectCamera(cameras[value-1].Name)
-						message := "Камера успешно выбрана."
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-						bot.Send(msg)
-						currentState = StateWork
-					}
+					message := "Введите уникальное имя новой камеры:"
+
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+					bot.Send(msg)
+					session.State = StateEnterName
 				}
 
-			case StateSelectPreset:
-				if update.Message.Text == "/cancel" {
-					message := "Выбор готовой камеры отменен. Введите следующую команду."
+			case "addpreset":
+				if !server.awake() {
+					message := "Важно - настройка камер невозможна при выключенной системе. Пожалуйста, выполните команду /awake для запуска.\n"
 					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
 					bot.Send(msg)
-					currentState = StateWork
 				} else {
-					if value, err := strconv.ParseInt(update.Message.Text, 10, 64); err == nil {
-						if value < 1 || value > int64(len(presets)) {
-							message := "Простите, но камеры с таким номером не существует. Введите другой номер или /cancel."
-							msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-							bot.Send(msg)
-							currentState = StateSelectPreset
-							continue
-						}
+					session.NewCamera.Name = ""
+					session.NewCamera.Type = -1
+					session.NewCamera.URL = ""
 
-						newCamera.Name = presets[value-1].Name
-						newCamera.Type = presets[value-1].Type
-						newCamera.URL = presets[value-1].URL
+					message := "Список доступных пресетов:\n"
 
-						err := addCamera(newCamera)
-						if err != nil {
-							message := "Сервер не отвечает, проверьте его состояние."
-							msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-							bot.Send(msg)
-							currentState = StateWork
-							continue
+					for i := 0; i < len(presets); i++ {
+						data := strconv.Itoa(i+1) + ") " + presets[i].Name + " ("
+						if presets[i].Type == 1 || presets[i].Type == 2 {
+							data += "RTSP)"
+						} else {
+							data += "Webcam)"
 						}
-
-						message := "Новая камера успешно создана. Вы можете ее увидеть в списке, введя команду /getcameras."
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-						bot.Send(msg)
-						currentState = StateWork
+						message += data + "\n"
 					}
-				}
+					message += "\n"
+					message += "Сделайте выбор, введя номер камеры в списке, например, 1 или 2. Для отмены введите /cancel."
 
-			case StateEnterName:
-				if update.Message.Text == "/cancel" {
-					message := "Создание новой камеры отменено. Введите следующую команду."
 					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
 					bot.Send(msg)
-					currentState = StateWork
-				} else {
-					if !isNameUnique(update.Message.Text) {
-						message := "Данное имя камеры уже занято. Пожалуйста, введите другое имя."
+					session.State = StateSelectPreset
+				}
+
+			case "camera":
+				handleCamera(bot, update.Message, server)
+
+			case "scene":
+				handleScene(bot, update.Message, server)
+
+			case "export":
+				handleExport(bot, update.Message, server)
+
+			case "import":
+				handleImport(bot, update.Message, session)
+
+			case "snapshot":
+				handleSnapshot(bot, update.Message, server)
+
+			case "preview":
+				handlePreviewSetting(bot, update.Message)
+
+			case "clip":
+				handleClip(bot, update.Message, server)
+
+			case "record":
+				handleRecord(bot, update.Message, server)
+
+			case "recordings":
+				handleRecordings(bot, update.Message, session)
+
+			case "cut":
+				handleCut(bot, update.Message, server)
+
+			case "server":
+				handleServer(bot, update.Message, session)
+
+			case "status":
+				handleStatus(bot, update.Message)
+
+			case "logs":
+				handleLogs(bot, update.Message, server)
+
+			case "services":
+				handleServices(bot, update.Message, server)
+
+			case "chatbot":
+				handleChatbot(bot, update.Message, server)
+			}
+
+		case StateSelectCamera:
+			if update.Message.Text == "/cancel" {
+				message := "Выбор камеры отменен. Введите следующую команду."
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+				bot.Send(msg)
+				session.State = StateWork
+			} else {
+				if value, err := strconv.ParseInt(update.Message.Text, 10, 64); err == nil {
+					cameras := server.cachedCameras()
+					if value < 1 || value > int64(len(cameras)) {
+						message := "Простите, но камеры с таким номером не существует. Введите другой номер или /cancel."
 						msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
 						bot.Send(msg)
-						currentState = StateEnterName
-						continue
+						session.State = StateSelectCamera
+						return
 					}
 
-					newCamera.Name = update.Message.Text
-
-					message := "Введите число от 0 до 2, описывающее тип новой камеры:\n"
-					message += "0 - USB-камера, подключенная к серверу;\n"
-					message += "1 - RTSP-камера, использующая протокол TCP;\n"
-					message += "2 - RTSP-камера, использующая протокол UDP."
+					name := cameras[value-1].Name
+					if !getSettings(update.Message.From.ID).SkipPreview {
+						sendPreview(bot, update.Message.Chat.ID, server, name)
+						session.PendingCamera = name
+						session.State = StateConfirmCamera
+						return
+					}
 
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-					bot.Send(msg)
-					currentState = StateEnterType
+					selectCameraAsync(bot, update.Message.Chat.ID, server, name)
+					session.State = StateWork
 				}
+			}
 
-			case StateEnterType:
-				if update.Message.Text == "/cancel" {
-					message := "Создание новой камеры отменено. Введите следующую команду."
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-					bot.Send(msg)
-					currentState = StateWork
-				} else {
-					if value, err := strconv.ParseInt(update.Message.Text, 10, 64); err == nil {
-						if value < 0 || value > 2 {
-							message := "Простите, но такого типа камер не существует. Введите другой тип или /cancel."
-							msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-							bot.Send(msg)
-							currentState = StateEnterType
-							continue
-						}
-
-						newCamera.Type = int(value)
+		case StateConfirmCamera:
+			if update.Message.Text == "/cancel" {
+				message := "Выбор камеры отменен. Введите следующую команду."
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+				bot.Send(msg)
+				session.PendingCamera = ""
+				session.State = StateWork
+			} else {
+				message := "Подтвердите переключение кнопкой под предпросмотром или введите /cancel."
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+				bot.Send(msg)
+			}
 
-						message := ""
+		case StateImportFile:
+			if update.Message.Text == "/cancel" {
+				message := "Импорт отменен. Введите следующую команду."
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+				bot.Send(msg)
+				session.State = StateWork
+			} else if update.Message.Document != nil {
+				handleImportFile(bot, update.Message, session)
+			} else {
+				message := "Отправьте файл конфигурации или введите /cancel."
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+				bot.Send(msg)
+			}
 
-						if newCamera.Type == 0 {
-							message = "Введите номер video-устройства, подключенного к серверу:\n"
-						} else {
-							message = "Введите полную строку подключения к RTSP камере (зависит от ее производителя), например:\n"
-							message += "rtsp://192.168.1.2:554/user=admin_password=abcdef_channel=1_stream=0.sdp?real_stream"
-						}
+		case StateConfirmImport:
+			if update.Message.Text == "/cancel" {
+				message := "Импорт отменен. Введите следующую команду."
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+				bot.Send(msg)
+				session.PendingImport = nil
+				session.State = StateWork
+			} else {
+				message := "Подтвердите импорт кнопкой под списком изменений или введите /cancel."
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+				bot.Send(msg)
+			}
 
+		case StateSelectPreset:
+			if update.Message.Text == "/cancel" {
+				message := "Выбор готовой камеры отменен. Введите следующую команду."
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+				bot.Send(msg)
+				session.State = StateWork
+			} else {
+				if value, err := strconv.ParseInt(update.Message.Text, 10, 64); err == nil {
+					if value < 1 || value > int64(len(presets)) {
+						message := "Простите, но камеры с таким номером не существует. Введите другой номер или /cancel."
 						msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
 						bot.Send(msg)
-						currentState = StateEnterURL
+						session.State = StateSelectPreset
+						return
 					}
+
+					session.NewCamera.Name = presets[value-1].Name
+					session.NewCamera.Type = presets[value-1].Type
+					session.NewCamera.URL = presets[value-1].URL
+
+					addCameraAsync(bot, update.Message.Chat.ID, server, session.NewCamera)
+					session.State = StateWork
 				}
+			}
 
-			case StateEnterURL:
-				if update.Message.Text == "/cancel" {
-					message := "Создание новой камеры отменено. Введите следующую команду."
+		case StateEnterName:
+			if update.Message.Text == "/cancel" {
+				message := "Создание новой камеры отменено. Введите следующую команду."
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+				bot.Send(msg)
+				session.State = StateWork
+			} else {
+				if !server.isNameUnique(update.Message.Text) {
+					message := "Данное имя камеры уже занято. Пожалуйста, введите другое имя."
 					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
 					bot.Send(msg)
-					currentState = StateWork
-				} else {
-					if newCamera.Type == 0 {
-						if value, err := strconv.ParseInt(update.Message.Text, 10, 64); err == nil {
-							if value < 0 {
-								message := "Простите, но такого номера камер не существует. Введите другой номер или /cancel."
-								msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-								bot.Send(msg)
-								currentState = StateEnterURL
-								continue
-							}
-							newCamera.URL = "/dev/video" + strconv.Itoa(int(value))
-						}
-					} else {
-						newCamera.URL = update.Message.Text
-					}
+					session.State = StateEnterName
+					return
+				}
 
-					err := addCamera(newCamera)
-					if err != nil {
-						message := "Сервер не отвечает, проверьте его состояние."
+				session.NewCamera.Name = update.Message.Text
+
+				message := "Введите число от 0 до 2, описывающее тип новой камеры:\n"
+				message += "0 - USB-камера, подключенная к серверу;\n"
+				message += "1 - RTSP-камера, использующая протокол TCP;\n"
+				message += "2 - RTSP-камера, использующая протокол UDP."
+
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+				bot.Send(msg)
+				session.State = StateEnterType
+			}
+
+		case StateEnterType:
+			if update.Message.Text == "/cancel" {
+				message := "Создание новой камеры отменено. Введите следующую команду."
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+				bot.Send(msg)
+				session.State = StateWork
+			} else {
+				if value, err := strconv.ParseInt(update.Message.Text, 10, 64); err == nil {
+					if value < 0 || value > 2 {
+						message := "Простите, но такого типа камер не существует. Введите другой тип или /cancel."
 						msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
 						bot.Send(msg)
-						currentState = StateWork
-						continue
+						session.State = StateEnterType
+						return
+					}
+
+					session.NewCamera.Type = int(value)
+
+					message := ""
+
+					if session.NewCamera.Type == 0 {
+						message = "Введите номер video-устройства, подключенного к серверу:\n"
+					} else {
+						message = "Введите полную строку подключения к RTSP камере (зависит от ее производителя), например:\n"
+						message += "rtsp://192.168.1.2:554/user=admin_password=abcdef_channel=1_stream=0.sdp?real_stream"
 					}
 
-					message := "Новая камера успешно создана. Вы можете ее увидеть в списке, введя команду /getcameras."
 					msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
 					bot.Send(msg)
-					currentState = StateWork
+					session.State = StateEnterURL
+				}
+			}
+
+		case StateEnterURL:
+			if update.Message.Text == "/cancel" {
+				message := "Создание новой камеры отменено. Введите следующую команду."
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+				bot.Send(msg)
+				session.State = StateWork
+			} else {
+				if session.NewCamera.Type == 0 {
+					if value, err := strconv.ParseInt(update.Message.Text, 10, 64); err == nil {
+						if value < 0 {
+							message := "Простите, но такого номера камер не существует. Введите другой номер или /cancel."
+							msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
+							bot.Send(msg)
+							session.State = StateEnterURL
+							return
+						}
+						session.NewCamera.URL = "/dev/video" + strconv.Itoa(int(value))
+					}
+				} else {
+					session.NewCamera.URL = update.Message.Text
 				}
+
+				addCameraAsync(bot, update.Message.Chat.ID, server, session.NewCamera)
+				session.State = StateWork
 			}
 		}
 	}
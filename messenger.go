@@ -0,0 +1,18 @@
+package main
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Messenger is the part of Telegram Bot API used by handlers. Texts, keyboards,
+// edits and files are sent as tgbotapi configs through Send, as the library does.
+// *tgbotapi.BotAPI implements it for Telegram, tests play conversations with scriptedMessenger.
+type Messenger interface {
+	GetUpdatesChan(config tgbotapi.UpdateConfig) (tgbotapi.UpdatesChannel, error)
+	StopReceivingUpdates()
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	AnswerCallbackQuery(config tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error)
+	GetFileDirectURL(fileID string) (string, error)
+}
+
+var _ Messenger = (*tgbotapi.BotAPI)(nil)
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// sentMessage is a record of what the bot sent through scriptedMessenger
+type sentMessage struct {
+	// Kind is text, edit, keyboard-edit, photo, video, document or callback
+	Kind      string
+	ChatID    int64
+	MessageID int
+	Text      string
+	// Buttons are callback data of inline keyboard buttons
+	Buttons []string
+}
+
+func (m sentMessage) String() string {
+	text := fmt.Sprintf("[%s #%d] %s", m.Kind, m.MessageID, m.Text)
+	if len(m.Buttons) > 0 {
+		text += "\n  кнопки: " + strings.Join(m.Buttons, ", ")
+	}
+	return text
+}
+
+// scriptedMessenger delivers prepared updates and records everything the bot sends
+type scriptedMessenger struct {
+	mu       sync.Mutex
+	updates  chan tgbotapi.Update
+	closed   bool
+	nextID   int
+	sent     []sentMessage
+	keyboard int
+	// failures are errors returned instead of sending messages of the kind
+	failures map[string]error
+	// files are contents of DocumentUpdate served by fileServer
+	files      map[string][]byte
+	fileServer *httptest.Server
+}
+
+// newScriptedMessenger creates messenger with room for size updates queued with Queue,
+// the file server is closed by Close
+func newScriptedMessenger(size int) *scriptedMessenger {
+	m := &scriptedMessenger{
+		updates:  make(chan tgbotapi.Update, size),
+		nextID:   1,
+		failures: map[string]error{},
+		files:    map[string][]byte{}}
+	m.fileServer = httptest.NewServer(http.HandlerFunc(m.serveFile))
+	return m
+}
+
+// Close stops the file server
+func (m *scriptedMessenger) Close() {
+	m.fileServer.Close()
+}
+
+// TextUpdate makes update with text message of the user, a leading slash makes it a command
+func (m *scriptedMessenger) TextUpdate(chatID int64, userID int, text string) tgbotapi.Update {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	message := &tgbotapi.Message{
+		MessageID: m.nextID,
+		From:      &tgbotapi.User{ID: userID},
+		Chat:      &tgbotapi.Chat{ID: chatID},
+		Text:      text}
+	m.nextID++
+
+	if strings.HasPrefix(text, "/") {
+		command, _, _ := strings.Cut(text, " ")
+		message.Entities = &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}}
+	}
+	return tgbotapi.Update{UpdateID: message.MessageID, Message: message}
+}
+
+// DocumentUpdate makes update with the file sent by the user, downloadFile receives the content from files
+func (m *scriptedMessenger) DocumentUpdate(chatID int64, userID int, name string, content []byte) tgbotapi.Update {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fileID := fmt.Sprintf("file-%d", m.nextID)
+	m.files[fileID] = content
+	message := &tgbotapi.Message{
+		MessageID: m.nextID,
+		From:      &tgbotapi.User{ID: userID},
+		Chat:      &tgbotapi.Chat{ID: chatID},
+		Document:  &tgbotapi.Document{FileID: fileID, FileName: name, FileSize: len(content)}}
+	m.nextID++
+	return tgbotapi.Update{UpdateID: message.MessageID, Message: message}
+}
+
+// CallbackUpdate makes update with press of inline button with the data on the last message with a keyboard
+func (m *scriptedMessenger) CallbackUpdate(chatID int64, userID int, data string) tgbotapi.Update {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query := &tgbotapi.CallbackQuery{
+		ID:   fmt.Sprintf("callback-%d", m.nextID),
+		From: &tgbotapi.User{ID: userID},
+		Message: &tgbotapi.Message{
+			MessageID: m.keyboard,
+			Chat:      &tgbotapi.Chat{ID: chatID}},
+		Data: data}
+	update := tgbotapi.Update{UpdateID: m.nextID, CallbackQuery: query}
+	m.nextID++
+	return update
+}
+
+// Queue delivers update through the update channel
+func (m *scriptedMessenger) Queue(update tgbotapi.Update) {
+	m.updates <- update
+}
+
+// Sent returns messages sent by the bot
+func (m *scriptedMessenger) Sent() []sentMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]sentMessage{}, m.sent...)
+}
+
+func (m *scriptedMessenger) GetUpdatesChan(config tgbotapi.UpdateConfig) (tgbotapi.UpdatesChannel, error) {
+	return m.updates, nil
+}
+
+// StopReceivingUpdates closes the update channel after the queued updates
+func (m *scriptedMessenger) StopReceivingUpdates() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		m.closed = true
+		close(m.updates)
+	}
+}
+
+// buttons returns callback data of inline keyboard
+func buttons(markup interface{}) []string {
+	var keyboard tgbotapi.InlineKeyboardMarkup
+	switch value := markup.(type) {
+	case tgbotapi.InlineKeyboardMarkup:
+		keyboard = value
+	case *tgbotapi.InlineKeyboardMarkup:
+		if value == nil {
+			return nil
+		}
+		keyboard = *value
+	default:
+		return nil
+	}
+
+	var data []string
+	for _, row := range keyboard.InlineKeyboard {
+		for _, button := range row {
+			if button.CallbackData != nil {
+				data = append(data, *button.CallbackData)
+			}
+		}
+	}
+	return data
+}
+
+func (m *scriptedMessenger) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var record sentMessage
+	switch config := c.(type) {
+	case tgbotapi.MessageConfig:
+		record = sentMessage{Kind: "text", ChatID: config.ChatID, Text: config.Text, Buttons: buttons(config.ReplyMarkup)}
+	case tgbotapi.EditMessageTextConfig:
+		record = sentMessage{Kind: "edit", ChatID: config.ChatID, MessageID: config.MessageID, Text: config.Text, Buttons: buttons(config.ReplyMarkup)}
+	case tgbotapi.EditMessageReplyMarkupConfig:
+		record = sentMessage{Kind: "keyboard-edit", ChatID: config.ChatID, MessageID: config.MessageID, Buttons: buttons(config.ReplyMarkup)}
+	case tgbotapi.PhotoConfig:
+		record = sentMessage{Kind: "photo", ChatID: config.ChatID, Text: config.Caption, Buttons: buttons(config.ReplyMarkup)}
+	case tgbotapi.VideoConfig:
+		record = sentMessage{Kind: "video", ChatID: config.ChatID, Text: config.Caption}
+	case tgbotapi.DocumentConfig:
+		record = sentMessage{Kind: "document", ChatID: config.ChatID, Text: config.Caption}
+	default:
+		record = sentMessage{Kind: fmt.Sprintf("%T", c)}
+	}
+
+	if err, ok := m.failures[record.Kind]; ok {
+		return tgbotapi.Message{}, err
+	}
+
+	// edits keep the message, new messages get a new ID
+	if record.MessageID == 0 {
+		record.MessageID = m.nextID
+		m.nextID++
+	}
+	if len(record.Buttons) > 0 {
+		m.keyboard = record.MessageID
+	}
+	m.sent = append(m.sent, record)
+	return tgbotapi.Message{MessageID: record.MessageID, Chat: &tgbotapi.Chat{ID: record.ChatID}}, nil
+}
+
+// Fail makes sending of messages of the kind fail with err, nil err makes it succeed again
+func (m *scriptedMessenger) Fail(kind string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		delete(m.failures, kind)
+		return
+	}
+	m.failures[kind] = err
+}
+
+// GetFileDirectURL returns URL of the file of DocumentUpdate on the file server
+func (m *scriptedMessenger) GetFileDirectURL(fileID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[fileID]; !ok {
+		return "", fmt.Errorf("unknown file %q", fileID)
+	}
+	return m.fileServer.URL + "/" + fileID, nil
+}
+
+// serveFile answers download of the file as Telegram file storage
+func (m *scriptedMessenger) serveFile(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	content, ok := m.files[strings.TrimPrefix(r.URL.Path, "/")]
+	m.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(content)
+}
+
+func (m *scriptedMessenger) AnswerCallbackQuery(config tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error) {
+	if config.Text != "" {
+		m.mu.Lock()
+		m.sent = append(m.sent, sentMessage{Kind: "callback", Text: config.Text})
+		m.mu.Unlock()
+	}
+	return tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// conversationStep is an update of the admin and the expected output, "kind: text" each.
+// A leading "!" presses the button with the data, "@" sends the file.
+type conversationStep struct {
+	in  string
+	out []string
+}
+
+func TestConversations(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []conversationStep
+	}{
+		{"commands need running system", []conversationStep{
+			{"/getactive", []string{"text: невозможна при выключенной системе"}},
+			{"/addcamera", []string{"text: невозможна при выключенной системе"}},
+			{"/import", []string{"text: невозможна при выключенной системе"}},
+		}},
+		{"unknown command and button are ignored", []conversationStep{
+			{"/dance", nil},
+			{"!dance", nil},
+		}},
+		{"files are accepted only by import", []conversationStep{
+			{"@cameras.json", []string{"text: Файлы принимаются только после команды /import."}},
+		}},
+		{"camera selection canceled", []conversationStep{
+			{"/awake", []string{"text: Запускаю систему", "edit: запущена"}},
+			{"/selectcamera", []string{"text: Сделайте выбор"}},
+			{"5", []string{"text: камеры с таким номером не существует"}},
+			{"/cancel", []string{"text: Выбор камеры отменен."}},
+			{"1", nil},
+		}},
+		{"camera creation canceled", []conversationStep{
+			{"/awake", []string{"text: Запускаю систему", "edit: запущена"}},
+			{"/addcamera", []string{"text: Введите уникальное имя"}},
+			{"Улица", []string{"text: Введите число от 0 до 2"}},
+			{"7", []string{"text: такого типа камер не существует"}},
+			{"/cancel", []string{"text: Создание новой камеры отменено."}},
+		}},
+		{"preview canceled with button", []conversationStep{
+			{"/awake", []string{"text: Запускаю систему", "edit: запущена"}},
+			{"/selectcamera", []string{"text: Сделайте выбор"}},
+			{"1", []string{"photo: Переключить трансляцию на камеру \"Коридор\"?"}},
+			{"Коридор", []string{"text: Подтвердите переключение кнопкой"}},
+			{"!" + callbackCancelCamera, []string{"keyboard-edit: ", "callback: Отменено", "text: Выбор камеры отменен."}},
+			{"!" + callbackConfirmCamera, []string{"keyboard-edit: ", "callback: Этот выбор уже неактуален."}},
+		}},
+		{"preview confirmed with button", []conversationStep{
+			{"/awake", []string{"text: Запускаю систему", "edit: запущена"}},
+			{"/selectcamera", []string{"text: Сделайте выбор"}},
+			{"1", []string{"photo: Переключить трансляцию"}},
+			{"!" + callbackConfirmCamera, []string{"keyboard-edit: ", "text: Переключаю камеру", "edit: Камера успешно выбрана."}},
+		}},
+		{"empty recordings list", []conversationStep{
+			{"/recordings вчера", []string{"text: Записи за "}},
+			{"!" + callbackRecordingsPage + "1", []string{"edit: ничего не найдено."}},
+			{"/recordings 25:00-26:00", []string{"text: непонятный параметр"}},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeFFmpeg(t)
+			b := newTestBot(t)
+			b.fake.addExisting(presets[0])
+
+			for _, step := range test.steps {
+				var got []sentMessage
+				if data, ok := strings.CutPrefix(step.in, "!"); ok {
+					got = b.press(data)
+				} else if name, ok := strings.CutPrefix(step.in, "@"); ok {
+					got = b.sendFile(name, []byte("{}"))
+				} else {
+					got = b.send(step.in)
+				}
+
+				if len(got) != len(step.out) {
+					t.Fatalf("%s: got %d messages, want %d:\n%v", step.in, len(got), len(step.out), got)
+				}
+				for i, want := range step.out {
+					kind, text, _ := strings.Cut(want, ": ")
+					if got[i].Kind != kind || !strings.Contains(got[i].Text, text) {
+						t.Errorf("%s: message %d is %v, want %s", step.in, i, got[i], want)
+					}
+				}
+			}
+		})
+	}
+}
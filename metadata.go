@@ -0,0 +1,310 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// metadataRetention is how long metadata of a camera missing on StreamServer is kept,
+// StreamServer forgets cameras on restart and they are usually added again
+const metadataRetention = 30 * 24 * time.Hour
+
+// CameraMeta is information about a camera kept by the bot, StreamServer knows only name and type
+type CameraMeta struct {
+	Description string   `json:"description,omitempty"`
+	Location    string   `json:"location,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Owner       string   `json:"owner,omitempty"`
+	// URL is the connection string when known, it recognizes renamed cameras
+	URL string `json:"url,omitempty"`
+	// MissingSince is set while StreamServer doesn't list the camera
+	MissingSince *time.Time `json:"missing_since,omitempty"`
+}
+
+// metaFields are names of CameraMeta fields in /camera command
+var metaFields = map[string]string{
+	"описание":      "description",
+	"description":   "description",
+	"место":         "location",
+	"location":      "location",
+	"теги":          "tags",
+	"tags":          "tags",
+	"ответственный": "owner",
+	"owner":         "owner"}
+
+// parseTags splits tags by commas and spaces, they are compared in lower case without "#"
+func parseTags(value string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, tag := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		tag = strings.ToLower(strings.TrimPrefix(tag, "#"))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// hasTag reports whether the metadata has the tag
+func (m *CameraMeta) hasTag(tag string) bool {
+	if m == nil {
+		return false
+	}
+	for _, item := range m.Tags {
+		if item == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// describe prints metadata lines of the camera list, empty metadata prints nothing
+func (m *CameraMeta) describe(indent string) string {
+	if m == nil {
+		return ""
+	}
+	text := ""
+	if m.Description != "" {
+		text += indent + m.Description + "\n"
+	}
+	if m.Location != "" {
+		text += indent + "Место: " + m.Location + "\n"
+	}
+	if m.Owner != "" {
+		text += indent + "Ответственный: " + m.Owner + "\n"
+	}
+	if len(m.Tags) > 0 {
+		text += indent + "Теги: #" + strings.Join(m.Tags, " #") + "\n"
+	}
+	return text
+}
+
+// clone returns copy of the metadata safe to use without the server lock
+func (m *CameraMeta) clone() *CameraMeta {
+	copied := *m
+	copied.Tags = append([]string{}, m.Tags...)
+	return &copied
+}
+
+// cameraMeta returns copy of metadata of the camera, nil if there is none
+func (s *StreamServer) cameraMeta(name string) *CameraMeta {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meta, ok := s.metadata[name]
+	if !ok {
+		return nil
+	}
+	return meta.clone()
+}
+
+// updateMeta changes metadata of the camera, metadata left empty is deleted
+func (s *StreamServer) updateMeta(name string, update func(meta *CameraMeta)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, ok := s.metadata[name]
+	if !ok {
+		meta = &CameraMeta{}
+		s.metadata[name] = meta
+	}
+	update(meta)
+	if source, ok := s.sources[name]; ok {
+		meta.URL = source.URL
+	}
+	if meta.Description == "" && meta.Location == "" && meta.Owner == "" && len(meta.Tags) == 0 {
+		delete(s.metadata, name)
+	}
+}
+
+// syncMetadata follows the camera list of StreamServer: metadata of a camera that appeared
+// with the connection string of a missing one is moved to the new name, metadata of missing
+// cameras is kept for metadataRetention in case they are added again. s.mu must be held.
+func (s *StreamServer) syncMetadata(cameras []AddCameraData) {
+	now := time.Now()
+	listed := map[string]bool{}
+	for _, camera := range cameras {
+		listed[camera.Name] = true
+	}
+
+	for name, meta := range s.metadata {
+		if listed[name] {
+			meta.MissingSince = nil
+			continue
+		}
+		if meta.MissingSince == nil {
+			missing := now
+			meta.MissingSince = &missing
+		}
+	}
+
+	for _, camera := range cameras {
+		url := camera.URL
+		if source, ok := s.sources[camera.Name]; ok && url == "" {
+			url = source.URL
+		}
+		if meta, ok := s.metadata[camera.Name]; ok {
+			if url != "" {
+				meta.URL = url
+			}
+			continue
+		}
+		if url == "" {
+			continue
+		}
+		for name, meta := range s.metadata {
+			if meta.MissingSince != nil && meta.URL == url {
+				s.log.Info("camera renamed, metadata moved", "from", name, "to", camera.Name)
+				meta.MissingSince = nil
+				s.metadata[camera.Name] = meta
+				delete(s.metadata, name)
+				break
+			}
+		}
+	}
+
+	for name, meta := range s.metadata {
+		if meta.MissingSince != nil && now.Sub(*meta.MissingSince) > metadataRetention {
+			s.log.Info("camera removed, metadata deleted", "camera", name)
+			delete(s.metadata, name)
+		}
+	}
+}
+
+// cameraList prints cameras with their metadata, keeping numbers of the full list.
+// With a tag only cameras having it are printed. Returns the text and the number of printed cameras.
+func cameraList(server *StreamServer, cameras []CameraData, tag string) (string, int) {
+	list := ""
+	shown := 0
+	for i, camera := range cameras {
+		meta := server.cameraMeta(camera.Name)
+		if tag != "" && !meta.hasTag(tag) {
+			continue
+		}
+		shown++
+
+		data := strconv.Itoa(i+1) + ") " + camera.Name + " ("
+		if camera.Type == 1 || camera.Type == 2 {
+			data += "RTSP)"
+		} else {
+			data += "Webcam)"
+		}
+		list += data + "\n" + meta.describe("    ")
+	}
+	return list, shown
+}
+
+// parseTagFilter returns tag of "tag:x" or "тег:x" argument of list commands
+func parseTagFilter(args string) string {
+	for _, field := range strings.Fields(args) {
+		for _, prefix := range []string{"tag:", "тег:"} {
+			if value, ok := strings.CutPrefix(strings.ToLower(field), prefix); ok {
+				return strings.TrimPrefix(value, "#")
+			}
+		}
+	}
+	return ""
+}
+
+// findCamera finds camera by the number in the last list or by the name
+func findCamera(cameras []CameraData, key string) (CameraData, bool) {
+	if value, err := strconv.Atoi(key); err == nil && value >= 1 && value <= len(cameras) {
+		return cameras[value-1], true
+	}
+	for _, camera := range cameras {
+		if camera.Name == key {
+			return camera, true
+		}
+	}
+	return CameraData{}, false
+}
+
+// handleCamera processes /camera <number|name> [field value] command.
+// Fields are описание, место, теги and ответственный, "-" clears the field.
+func handleCamera(bot Messenger, message *tgbotapi.Message, server *StreamServer) {
+	reply := func(text string) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, text)
+		bot.Send(msg)
+	}
+
+	fields := strings.Fields(message.CommandArguments())
+	if len(fields) == 0 {
+		reply("Введите /camera номер или имя камеры, чтобы посмотреть ее описание, или /camera номер поле значение, чтобы изменить его.\n" +
+			"Поля: описание, место, теги, ответственный. Значение \"-\" очищает поле. Например: /camera 1 теги лекции, улица")
+		return
+	}
+
+	// the name may contain spaces, it ends before the first field keyword
+	key, field, value := strings.Join(fields, " "), "", ""
+	for i := 1; i < len(fields); i++ {
+		if name, ok := metaFields[strings.ToLower(fields[i])]; ok {
+			key, field, value = strings.Join(fields[:i], " "), name, strings.Join(fields[i+1:], " ")
+			break
+		}
+	}
+
+	camera, ok := findCamera(server.cachedCameras(), key)
+	if !ok {
+		reply("Камера \"" + key + "\" не найдена. Обновите список командой /getcameras.")
+		return
+	}
+
+	if field == "" {
+		meta := server.cameraMeta(camera.Name).describe("")
+		if meta == "" {
+			meta = "Описание не задано.\n"
+		}
+		reply("Камера " + camera.Name + ":\n" + meta)
+		return
+	}
+	if value == "" {
+		reply("Введите значение поля или \"-\", чтобы очистить его.")
+		return
+	}
+	if value == "-" {
+		value = ""
+	}
+
+	server.updateMeta(camera.Name, func(meta *CameraMeta) {
+		switch field {
+		case "description":
+			meta.Description = value
+		case "location":
+			meta.Location = value
+		case "owner":
+			meta.Owner = value
+		case "tags":
+			meta.Tags = parseTags(value)
+		}
+	})
+
+	server.log.Info("camera metadata changed", "camera", camera.Name, "field", field)
+	text := server.cameraMeta(camera.Name).describe("")
+	if text == "" {
+		text = "Описание не задано.\n"
+	}
+	reply("Камера " + camera.Name + ":\n" + text)
+}
+
+// knownTags lists tags of all cameras of the server for hints
+func knownTags(server *StreamServer) []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	seen := map[string]bool{}
+	var tags []string
+	for _, meta := range server.metadata {
+		for _, tag := range meta.Tags {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Threshold raises an alert when a metric reaches High and clears it when the metric
+// falls to Low, so a value oscillating around one limit doesn't flood admins
+type Threshold struct {
+	High float64 `json:"high"`
+	// Low is 90% of High by default
+	Low float64 `json:"low"`
+}
+
+// MonitorConfig describes host monitoring, a metric without threshold is not checked
+type MonitorConfig struct {
+	// Interval between checks in seconds
+	Interval int `json:"interval"`
+	// CPU is usage of all cores in percent
+	CPU *Threshold `json:"cpu,omitempty"`
+	// Memory is used memory in percent
+	Memory *Threshold `json:"memory,omitempty"`
+	// Disk is used space of the file system with recordings in percent
+	Disk *Threshold `json:"disk,omitempty"`
+	// Network is receive or transmit rate of any interface in Mbit/s
+	Network *Threshold `json:"network,omitempty"`
+	// Temperature is the hottest thermal zone in degrees Celsius
+	Temperature *Threshold `json:"temperature,omitempty"`
+}
+
+// defaultMonitor is used when the configuration has no monitor section
+var defaultMonitor = MonitorConfig{
+	Interval:    60,
+	CPU:         &Threshold{High: 90, Low: 75},
+	Memory:      &Threshold{High: 90, Low: 80},
+	Disk:        &Threshold{High: 90, Low: 85},
+	Temperature: &Threshold{High: 85, Low: 75}}
+
+var monitorConfig = defaultMonitor
+
+// setupMonitor validates monitor configuration, nil means defaults
+func setupMonitor(config *MonitorConfig) error {
+	if config == nil {
+		return nil
+	}
+	if config.Interval <= 0 {
+		return errors.New("monitor interval must be positive")
+	}
+	for _, threshold := range []*Threshold{config.CPU, config.Memory, config.Disk, config.Network, config.Temperature} {
+		if threshold == nil {
+			continue
+		}
+		if threshold.High <= 0 {
+			return errors.New("monitor threshold must be positive")
+		}
+		if threshold.Low == 0 {
+			threshold.Low = threshold.High * 0.9
+		}
+		if threshold.Low > threshold.High {
+			return fmt.Errorf("monitor threshold low %g is above high %g", threshold.Low, threshold.High)
+		}
+	}
+	monitorConfig = *config
+	return nil
+}
+
+// hostMonitor keeps previous counters and raised alerts between checks
+type hostMonitor struct {
+	config MonitorConfig
+	// alerts are keys of metrics above their thresholds
+	alerts map[string]bool
+
+	checked  time.Time
+	cpuTotal uint64
+	cpuIdle  uint64
+	network  map[string]interfaceCounters
+}
+
+// evaluate compares value with threshold and returns message when the alert is raised or cleared
+func (m *hostMonitor) evaluate(key string, title string, unit string, value float64, threshold *Threshold) string {
+	if threshold == nil {
+		return ""
+	}
+
+	switch {
+	case !m.alerts[key] && value >= threshold.High:
+		m.alerts[key] = true
+		systemLog.Warn("host metric above threshold", "metric", key, "value", value, "threshold", threshold.High)
+		return fmt.Sprintf("Внимание: %s %.0f%s, порог %.0f%s", title, value, unit, threshold.High, unit)
+	case m.alerts[key] && value <= threshold.Low:
+		delete(m.alerts, key)
+		systemLog.Info("host metric back to normal", "metric", key, "value", value)
+		return fmt.Sprintf("В норме: %s %.0f%s", title, value, unit)
+	}
+	return ""
+}
+
+// clearMissingInterfaces clears alerts of interfaces that are gone, they are never evaluated again.
+// Returns messages about the cleared alerts, one per interface.
+func (m *hostMonitor) clearMissingInterfaces(counters map[string]interfaceCounters) []string {
+	gone := map[string]bool{}
+	for key := range m.alerts {
+		rest, ok := strings.CutPrefix(key, "network:")
+		if !ok {
+			continue
+		}
+		name := rest[:strings.LastIndex(rest, ":")]
+		if _, present := counters[name]; !present {
+			delete(m.alerts, key)
+			gone[name] = true
+		}
+	}
+
+	var messages []string
+	for name := range gone {
+		systemLog.Info("interface is gone, its alerts are cleared", "interface", name)
+		messages = append(messages, "Интерфейс "+name+" пропал, предупреждения о нем сняты")
+	}
+	sort.Strings(messages)
+	return messages
+}
+
+// check reads host metrics and returns alerts to send
+func (m *hostMonitor) check() []string {
+	var messages []string
+	add := func(message string) {
+		if message != "" {
+			messages = append(messages, message)
+		}
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(m.checked).Seconds()
+	first := m.checked.IsZero()
+	m.checked = now
+
+	// CPU usage and network rates are measured since the previous check
+	if total, idle, err := cpuTimes(); err != nil {
+		systemLog.Debug("failed to read CPU usage", "err", err)
+	} else {
+		if !first && total > m.cpuTotal {
+			usage := 100 * float64((total-m.cpuTotal)-(idle-m.cpuIdle)) / float64(total-m.cpuTotal)
+			add(m.evaluate("cpu", "загрузка CPU", "%", usage, m.config.CPU))
+		}
+		m.cpuTotal, m.cpuIdle = total, idle
+	}
+
+	if total, available, err := memoryInfo(); err != nil {
+		systemLog.Debug("failed to read memory info", "err", err)
+	} else {
+		add(m.evaluate("memory", "занято памяти", "%", 100*float64(total-available)/float64(total), m.config.Memory))
+	}
+
+	diskPath := recordDiskPath()
+	if total, free, err := diskUsage(diskPath); err != nil {
+		systemLog.Debug("failed to read disk usage", "path", diskPath, "err", err)
+	} else if total > 0 {
+		add(m.evaluate("disk", "занято места на диске с записями", "%", 100*float64(total-free)/float64(total), m.config.Disk))
+	}
+
+	if counters, err := networkCounters(); err != nil {
+		systemLog.Debug("failed to read network counters", "err", err)
+	} else {
+		for name, current := range counters {
+			previous, ok := m.network[name]
+			// counters are reset when the interface is recreated
+			if first || !ok || current.Received < previous.Received || current.Transmitted < previous.Transmitted || elapsed <= 0 {
+				continue
+			}
+			received := float64(current.Received-previous.Received) * 8 / elapsed / 1e6
+			transmitted := float64(current.Transmitted-previous.Transmitted) * 8 / elapsed / 1e6
+			add(m.evaluate("network:"+name+":rx", "прием на "+name, " Мбит/с", received, m.config.Network))
+			add(m.evaluate("network:"+name+":tx", "передача на "+name, " Мбит/с", transmitted, m.config.Network))
+		}
+		for _, message := range m.clearMissingInterfaces(counters) {
+			add(message)
+		}
+		m.network = counters
+	}
+
+	if temperature, err := cpuTemperature(); err == nil {
+		add(m.evaluate("temperature", "температура", " °C", temperature, m.config.Temperature))
+	}
+
+	return messages
+}
+
+// runMonitor checks host resources every interval and alerts admins until ctx is done
+func runMonitor(ctx context.Context, bot Messenger) {
+	monitor := &hostMonitor{
+		config: monitorConfig,
+		alerts: map[string]bool{}}
+
+	hostname, _ := os.Hostname()
+	ticker := time.NewTicker(time.Duration(monitor.config.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if messages := monitor.check(); len(messages) > 0 {
+			report := "Хост бота " + hostname + ":\n"
+			for _, message := range messages {
+				report += message + "\n"
+			}
+			notifyAdmins(bot, report)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
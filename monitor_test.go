@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMonitorAlerts(t *testing.T) {
+	threshold := &Threshold{High: 100, Low: 80}
+	m := &hostMonitor{alerts: map[string]bool{}}
+
+	steps := []struct {
+		value float64
+		want  string
+	}{
+		{50, ""},
+		{120, "Внимание: прием на eth1 120 Мбит/с, порог 100 Мбит/с"},
+		{130, ""},
+		{90, ""},
+		{70, "В норме: прием на eth1 70 Мбит/с"},
+		{75, ""},
+	}
+	for _, step := range steps {
+		if got := m.evaluate("network:eth1:rx", "прием на eth1", " Мбит/с", step.value, threshold); got != step.want {
+			t.Errorf("value %g: message is %q, want %q", step.value, got, step.want)
+		}
+	}
+}
+
+func TestMonitorClearsMissingInterfaces(t *testing.T) {
+	m := &hostMonitor{alerts: map[string]bool{
+		"network:eth1:rx":    true,
+		"network:eth1:tx":    true,
+		"network:eth0:rx":    true,
+		"network:wlan0:1:tx": true,
+		"cpu":                true}}
+
+	messages := m.clearMissingInterfaces(map[string]interfaceCounters{"eth0": {}})
+	want := []string{"Интерфейс eth1 пропал, предупреждения о нем сняты", "Интерфейс wlan0:1 пропал, предупреждения о нем сняты"}
+	if !reflect.DeepEqual(messages, want) {
+		t.Errorf("messages are %v, want %v", messages, want)
+	}
+	if !reflect.DeepEqual(m.alerts, map[string]bool{"network:eth0:rx": true, "cpu": true}) {
+		t.Errorf("alerts left are %v", m.alerts)
+	}
+	if messages := m.clearMissingInterfaces(map[string]interfaceCounters{"eth0": {}}); len(messages) > 0 {
+		t.Errorf("cleared alerts are reported again: %v", messages)
+	}
+}
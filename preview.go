@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Callback data of preview buttons.
+const (
+	callbackConfirmCamera = "confirm-camera"
+	callbackCancelCamera  = "cancel-camera"
+)
+
+// previewKeyboard returns Confirm/Cancel buttons for camera switching
+func previewKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Переключить", callbackConfirmCamera),
+			tgbotapi.NewInlineKeyboardButtonData("Отмена", callbackCancelCamera)))
+}
+
+// sendPreview sends snapshot of the camera with confirmation buttons.
+// If snapshot can't be taken, the buttons are sent with explanation.
+func sendPreview(bot Messenger, chatID int64, server *StreamServer, name string) {
+	question := "Переключить трансляцию на камеру \"" + name + "\"?"
+
+	source, ok := server.cameraSource(name)
+	if !ok {
+		question = "Предпросмотр недоступен: строка подключения камеры неизвестна.\n\n" + question
+	} else if frame, err := server.getSnapshot(source); err != nil {
+		question = "Предпросмотр недоступен: " + redact(err.Error()) + "\n\n" + question
+	} else {
+		photo := tgbotapi.NewPhotoUpload(chatID, tgbotapi.FileBytes{
+			Name:  "preview.jpg",
+			Bytes: frame})
+		photo.Caption = question
+		photo.ReplyMarkup = previewKeyboard()
+		if _, err = bot.Send(photo); err == nil {
+			return
+		}
+		botLog.Error("failed to send preview", "camera", name, "err", err)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, question)
+	msg.ReplyMarkup = previewKeyboard()
+	bot.Send(msg)
+}
+
+// handlePreviewAnswer switches camera after confirmation or cancels the choice
+func handlePreviewAnswer(bot Messenger, query *tgbotapi.CallbackQuery, session *Session) {
+	chatID := query.Message.Chat.ID
+
+	// Buttons are not needed anymore
+	bot.Send(tgbotapi.NewEditMessageReplyMarkup(chatID, query.Message.MessageID, tgbotapi.NewInlineKeyboardMarkup()))
+
+	if session.State != StateConfirmCamera || session.PendingCamera == "" {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, "Этот выбор уже неактуален."))
+		return
+	}
+
+	name := session.PendingCamera
+	session.PendingCamera = ""
+	session.State = StateWork
+
+	if query.Data == callbackCancelCamera {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, "Отменено"))
+		msg := tgbotapi.NewMessage(chatID, "Выбор камеры отменен. Введите следующую команду.")
+		bot.Send(msg)
+		return
+	}
+
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, ""))
+	selectCameraAsync(bot, chatID, sessionServer(session), name)
+}
+
+// handlePreviewSetting processes /preview on|off command
+func handlePreviewSetting(bot Messenger, message *tgbotapi.Message) {
+	argument := strings.TrimSpace(message.CommandArguments())
+	userSettings := updateSettings(message.From.ID, func(userSettings *UserSettings) {
+		switch argument {
+		case "on":
+			userSettings.SkipPreview = false
+		case "off":
+			userSettings.SkipPreview = true
+		}
+	})
+
+	reply := "Предпросмотр перед переключением камеры включен."
+	if userSettings.SkipPreview {
+		reply = "Предпросмотр перед переключением камеры выключен."
+	}
+	reply += " Изменить: /preview on или /preview off."
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, reply)
+	bot.Send(msg)
+}
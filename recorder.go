@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// segmentLayout is used in names of recorded segments, e.g. 20200513-140000.mp4
+const segmentLayout = "20060102-150405"
+
+// Recorders are configured through environment variables:
+//
+//	RECORD_DIR      - directory for segments, "recordings" by default, each server has its subdirectory
+//	RECORD_SEGMENT  - segment length in seconds, 300 by default
+//	RECORD_MAX_AGE  - segments older than this number of hours are removed, 168 by default
+//	RECORD_MAX_SIZE - total size of segments of one server in megabytes, 20480 by default
+//	EXPORT_DIR      - directory for intervals exported with /cut, export is disabled when it is empty
+var (
+	recordDir               = "recordings"
+	exportDir               = ""
+	recordSegmentTime       = 5 * time.Minute
+	recordMaxAge            = 7 * 24 * time.Hour
+	recordMaxSize     int64 = 20 << 30
+)
+
+// Segment describes one recorded file of the broadcast
+type Segment struct {
+	File    string    `json:"file"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Size    int64     `json:"size"`
+	Cameras []string  `json:"cameras"`
+}
+
+// cameraChange is a moment when the broadcast was switched to the camera
+type cameraChange struct {
+	Time   time.Time
+	Camera string
+}
+
+// Recorder writes the broadcast to disk in fixed-length segments
+type Recorder struct {
+	Dir         string
+	SegmentTime time.Duration
+	MaxAge      time.Duration
+	MaxSize     int64
+
+	mu       sync.Mutex
+	running  bool
+	started  time.Time
+	timeline []cameraChange
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// setupRecorder reads recorder settings from environment
+func setupRecorder() error {
+	var errs []error
+
+	if value := os.Getenv("RECORD_DIR"); value != "" {
+		recordDir = value
+	}
+	exportDir = os.Getenv("EXPORT_DIR")
+	if value := os.Getenv("RECORD_SEGMENT"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 {
+			errs = append(errs, fmt.Errorf("invalid RECORD_SEGMENT: %q", value))
+		} else {
+			recordSegmentTime = time.Duration(seconds) * time.Second
+		}
+	}
+	if value := os.Getenv("RECORD_MAX_AGE"); value != "" {
+		hours, err := strconv.Atoi(value)
+		if err != nil || hours < 1 {
+			errs = append(errs, fmt.Errorf("invalid RECORD_MAX_AGE: %q", value))
+		} else {
+			recordMaxAge = time.Duration(hours) * time.Hour
+		}
+	}
+	if value := os.Getenv("RECORD_MAX_SIZE"); value != "" {
+		megabytes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || megabytes < 1 {
+			errs = append(errs, fmt.Errorf("invalid RECORD_MAX_SIZE: %q", value))
+		} else {
+			recordMaxSize = megabytes << 20
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// newRecorder creates recorder of the server with current settings
+func newRecorder(server string) *Recorder {
+	return &Recorder{
+		Dir:         filepath.Join(recordDir, server),
+		SegmentTime: recordSegmentTime,
+		MaxAge:      recordMaxAge,
+		MaxSize:     recordMaxSize}
+}
+
+// Start begins recording of the stream, camera is the one active at the moment
+func (r *Recorder) Start(streamURL string, camera string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.running {
+		return errors.New("recording is already running")
+	}
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return err
+	}
+
+	r.running = true
+	r.started = time.Now()
+	// segments of the previous recording are already tagged, its cameras don't belong to this one
+	r.timeline = []cameraChange{{Time: r.started, Camera: camera}}
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+
+	go r.run(streamURL, r.stop, r.done)
+
+	systemLog.Info("recording started", "dir", r.Dir, "camera", camera)
+	return nil
+}
+
+// Stop finishes recording and tags the last segment
+func (r *Recorder) Stop() {
+	r.mu.Lock()
+	if !r.running {
+		r.mu.Unlock()
+		return
+	}
+	r.running = false
+	close(r.stop)
+	done := r.done
+	r.mu.Unlock()
+
+	<-done
+	systemLog.Info("recording stopped")
+}
+
+// IsRunning reports whether recording is active
+func (r *Recorder) IsRunning() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running
+}
+
+// CameraChanged remembers that the broadcast was switched to the camera
+func (r *Recorder) CameraChanged(camera string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running {
+		r.timeline = append(r.timeline, cameraChange{Time: time.Now(), Camera: camera})
+	}
+}
+
+// run keeps ffmpeg working until stop is closed, restarting it when the stream breaks
+func (r *Recorder) run(streamURL string, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		cmd := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "error",
+			"-i", streamURL,
+			"-c", "copy",
+			"-f", "segment",
+			"-segment_time", strconv.Itoa(int(r.SegmentTime.Seconds())),
+			"-segment_format", "mp4",
+			"-segment_format_options", "movflags=+faststart",
+			"-reset_timestamps", "1",
+			"-strftime", "1",
+			filepath.Join(r.Dir, "%Y%m%d-%H%M%S.mp4"))
+
+		exited := make(chan error, 1)
+		if err := cmd.Start(); err != nil {
+			exited <- err
+		} else {
+			go func() {
+				exited <- cmd.Wait()
+			}()
+		}
+
+	wait:
+		for {
+			select {
+			case <-stop:
+				if cmd.Process != nil {
+					// ffmpeg finalizes the current file on interrupt
+					cmd.Process.Signal(os.Interrupt)
+					select {
+					case <-exited:
+					case <-time.After(10 * time.Second):
+						cmd.Process.Kill()
+						<-exited
+					}
+				}
+				r.maintain(true)
+				return
+
+			case err := <-exited:
+				systemLog.Warn("recording interrupted, restarting", "err", err)
+				break wait
+
+			case <-ticker.C:
+				r.maintain(false)
+			}
+		}
+
+		select {
+		case <-stop:
+			r.maintain(true)
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// listSegments returns recorded files sorted by start time
+func (r *Recorder) listSegments() ([]Segment, error) {
+	entries, err := os.ReadDir(r.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []Segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".mp4" {
+			continue
+		}
+		start, err := time.ParseInLocation(segmentLayout, strings.TrimSuffix(name, ".mp4"), time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		segment := Segment{
+			File:  name,
+			Start: start,
+			End:   info.ModTime(),
+			Size:  info.Size()}
+
+		if payload, err := os.ReadFile(r.tagPath(name)); err == nil {
+			var tagged Segment
+			if err := json.Unmarshal(payload, &tagged); err == nil {
+				segment.End = tagged.End
+				segment.Cameras = tagged.Cameras
+			}
+		}
+		segments = append(segments, segment)
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Start.Before(segments[j].Start)
+	})
+	return segments, nil
+}
+
+// tagPath returns path of the file with segment tags
+func (r *Recorder) tagPath(file string) string {
+	return filepath.Join(r.Dir, strings.TrimSuffix(file, ".mp4")+".json")
+}
+
+// camerasBetween returns cameras broadcasting during the time range
+func (r *Recorder) camerasBetween(start, end time.Time) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []string
+	for i, change := range r.timeline {
+		if !change.Time.Before(end) {
+			break
+		}
+		if i+1 < len(r.timeline) && !r.timeline[i+1].Time.After(start) {
+			continue
+		}
+		if len(result) == 0 || result[len(result)-1] != change.Camera {
+			result = append(result, change.Camera)
+		}
+	}
+	return result
+}
+
+// maintain tags finished segments and applies retention policy.
+// The newest segment is still being written unless final is set.
+func (r *Recorder) maintain(final bool) {
+	segments, err := r.listSegments()
+	if err != nil {
+		systemLog.Error("failed to list recordings", "err", err)
+		return
+	}
+
+	finished := segments
+	if !final && len(finished) > 0 {
+		finished = finished[:len(finished)-1]
+	}
+
+	for _, segment := range finished {
+		if segment.Cameras != nil {
+			continue
+		}
+		segment.Cameras = r.camerasBetween(segment.Start, segment.End)
+		if segment.Cameras == nil {
+			segment.Cameras = []string{}
+		}
+		payload, _ := json.Marshal(segment)
+		if err := os.WriteFile(r.tagPath(segment.File), payload, 0644); err != nil {
+			systemLog.Error("failed to tag segment", "file", segment.File, "err", err)
+		}
+	}
+
+	r.applyRetention(segments, final)
+	r.trimTimeline()
+}
+
+// applyRetention removes segments older than MaxAge and the oldest ones while
+// total size exceeds MaxSize. The segment being written is never removed.
+func (r *Recorder) applyRetention(segments []Segment, final bool) {
+	var total int64
+	for _, segment := range segments {
+		total += segment.Size
+	}
+
+	removable := segments
+	if !final && len(removable) > 0 {
+		removable = removable[:len(removable)-1]
+	}
+
+	for _, segment := range removable {
+		if time.Since(segment.End) < r.MaxAge && total <= r.MaxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(r.Dir, segment.File)); err != nil {
+			systemLog.Error("failed to remove segment", "file", segment.File, "err", err)
+			continue
+		}
+		os.Remove(r.tagPath(segment.File))
+		total -= segment.Size
+		systemLog.Info("segment removed by retention policy", "file", segment.File)
+	}
+}
+
+// trimTimeline forgets camera changes that can't be needed for tagging anymore
+func (r *Recorder) trimTimeline() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	limit := time.Now().Add(-2 * r.SegmentTime)
+	for len(r.timeline) > 1 && r.timeline[1].Time.Before(limit) {
+		r.timeline = r.timeline[1:]
+	}
+}
+
+// recordStatus describes recorder state for admins
+func recordStatus(recorder *Recorder) string {
+	message := ""
+	if recorder.IsRunning() {
+		recorder.mu.Lock()
+		started := recorder.started
+		camera := ""
+		if len(recorder.timeline) > 0 {
+			camera = recorder.timeline[len(recorder.timeline)-1].Camera
+		}
+		recorder.mu.Unlock()
+
+		message = "Запись идет с " + started.Format("02.01.2006 15:04:05") + "\n"
+		if camera != "" {
+			message += "Текущая камера: " + camera + "\n"
+		}
+	} else {
+		message = "Запись остановлена.\n"
+	}
+
+	segments, err := recorder.listSegments()
+	if err != nil {
+		return message + "Не удалось прочитать архив записей."
+	}
+
+	var total int64
+	for _, segment := range segments {
+		total += segment.Size
+	}
+	message += fmt.Sprintf("В архиве %d фрагментов, %.1f МБ из %d МБ.", len(segments), float64(total)/(1<<20), recorder.MaxSize>>20)
+	return message
+}
+
+// handleRecord processes /record start|stop|status command on the server
+func handleRecord(bot Messenger, message *tgbotapi.Message, server *StreamServer) {
+	recorder := server.recorder
+	reply := ""
+
+	switch strings.TrimSpace(message.CommandArguments()) {
+	case "start":
+		if !server.awake() {
+			reply = "Важно - запись невозможна при выключенной системе. Пожалуйста, выполните команду /awake для запуска."
+			break
+		}
+		if recorder.IsRunning() {
+			reply = "Запись уже идет."
+			break
+		}
+
+		streamURL := server.getStreamURL()
+		if streamURL == "" {
+			reply = "StreamServer не сообщил адрес трансляции, запись невозможна. Проверьте его состояние командой /status."
+			break
+		}
+		cam, err := server.getActive()
+		if err != nil {
+			reply = serverError(err)
+			break
+		}
+		if err := recorder.Start(streamURL, cam.Name); err != nil {
+			systemLog.Error("failed to start recording", "err", err)
+			reply = "Не удалось начать запись."
+			break
+		}
+		reply = "Запись трансляции начата."
+
+	case "stop":
+		if !recorder.IsRunning() {
+			reply = "Запись не ведется."
+			break
+		}
+		recorder.Stop()
+		reply = "Запись трансляции остановлена."
+
+	case "status":
+		reply = recordStatus(recorder)
+
+	default:
+		reply = "Используйте /record start, /record stop или /record status."
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, reply)
+	bot.Send(msg)
+}
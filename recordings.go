@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// recordingsPageSize is the number of segments on one page of /recordings
+const recordingsPageSize = 8
+
+// Callback data prefixes of /recordings buttons.
+const (
+	callbackRecordingsPage = "rec:page:"
+	callbackRecordingsGet  = "rec:get:"
+	callbackRecordingsCut  = "rec:cut"
+)
+
+// recordingQuery selects segments by time range and camera, zero time means no limit
+type recordingQuery struct {
+	From     time.Time
+	To       time.Time
+	Camera   string
+	HasRange bool
+}
+
+// parseDay understands today, yesterday, 2006-01-02, 02.01.2006 and 02.01
+func parseDay(value string, now time.Time) (time.Time, bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch strings.ToLower(value) {
+	case "today", "сегодня":
+		return today, true
+	case "yesterday", "вчера":
+		return today.AddDate(0, 0, -1), true
+	}
+
+	for _, layout := range []string{"2006-01-02", "02.01.2006"} {
+		if day, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return day, true
+		}
+	}
+	if day, err := time.ParseInLocation("02.01", value, now.Location()); err == nil {
+		return time.Date(now.Year(), day.Month(), day.Day(), 0, 0, 0, 0, now.Location()), true
+	}
+	return time.Time{}, false
+}
+
+// parseClock parses 15:04 into offset from the beginning of a day
+func parseClock(value string) (time.Duration, bool) {
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, true
+}
+
+// parseRecordingQuery parses "[day] [HH:MM-HH:MM] [camera:name]", camera name takes the rest of the line
+func parseRecordingQuery(args string, now time.Time) (recordingQuery, error) {
+	query := recordingQuery{}
+
+	if i := strings.Index(args, "camera:"); i >= 0 {
+		query.Camera = strings.TrimSpace(args[i+len("camera:"):])
+		args = args[:i]
+	}
+
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	hasDay := false
+	var from, to time.Duration
+
+	for _, field := range strings.Fields(args) {
+		if value, ok := parseDay(field, now); ok && !hasDay {
+			day = value
+			hasDay = true
+			continue
+		}
+
+		start, end, found := strings.Cut(field, "-")
+		if found && !query.HasRange {
+			startClock, okStart := parseClock(start)
+			endClock, okEnd := parseClock(end)
+			if okStart && okEnd {
+				if endClock <= startClock {
+					return query, errors.New("конец интервала должен быть позже начала")
+				}
+				from, to = startClock, endClock
+				query.HasRange = true
+				continue
+			}
+		}
+
+		return query, fmt.Errorf("непонятный параметр \"%s\"", field)
+	}
+
+	switch {
+	case query.HasRange:
+		query.From = day.Add(from)
+		query.To = day.Add(to)
+	case hasDay:
+		query.From = day
+		query.To = day.AddDate(0, 0, 1)
+	}
+	return query, nil
+}
+
+// matches reports whether the segment overlaps the query range and shows the camera
+func (q recordingQuery) matches(segment Segment) bool {
+	if !q.From.IsZero() && !segment.End.After(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !segment.Start.Before(q.To) {
+		return false
+	}
+	if q.Camera == "" {
+		return true
+	}
+	for _, camera := range segment.Cameras {
+		if strings.EqualFold(camera, q.Camera) {
+			return true
+		}
+	}
+	return false
+}
+
+// describe returns human readable description of the query
+func (q recordingQuery) describe() string {
+	description := "Записи"
+	switch {
+	case q.HasRange:
+		description += " за " + q.From.Format("02.01.2006 15:04") + "–" + q.To.Format("15:04")
+	case !q.From.IsZero():
+		description += " за " + q.From.Format("02.01.2006")
+	}
+	if q.Camera != "" {
+		description += ", камера " + q.Camera
+	}
+	return description
+}
+
+// finishedSegments returns segments that can be read, the one being recorded is skipped
+func (r *Recorder) finishedSegments() ([]Segment, error) {
+	segments, err := r.listSegments()
+	if err != nil {
+		return nil, err
+	}
+	if r.IsRunning() && len(segments) > 0 {
+		segments = segments[:len(segments)-1]
+	}
+	return segments, nil
+}
+
+// findSegments returns finished segments matching the query, newest first
+func (r *Recorder) findSegments(query recordingQuery) ([]Segment, error) {
+	segments, err := r.finishedSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Segment
+	for i := len(segments) - 1; i >= 0; i-- {
+		if query.matches(segments[i]) {
+			result = append(result, segments[i])
+		}
+	}
+	return result, nil
+}
+
+// recordingsPage builds text and keyboard of one page of the list
+func recordingsPage(recorder *Recorder, query recordingQuery, page int) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	segments, err := recorder.findSegments(query)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+
+	pages := (len(segments) + recordingsPageSize - 1) / recordingsPageSize
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	text := query.describe() + ": "
+	if len(segments) == 0 {
+		text += "ничего не найдено."
+		return text, tgbotapi.NewInlineKeyboardMarkup(), nil
+	}
+	text += fmt.Sprintf("найдено %d, страница %d из %d.\n\n", len(segments), page+1, pages)
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	end := page*recordingsPageSize + recordingsPageSize
+	if end > len(segments) {
+		end = len(segments)
+	}
+
+	for _, segment := range segments[page*recordingsPageSize : end] {
+		label := segment.Start.Format("02.01 15:04:05") + "–" + segment.End.Format("15:04:05")
+		text += label + " " + strings.Join(segment.Cameras, ", ") + fmt.Sprintf(" (%.1f МБ)\n", float64(segment.Size)/(1<<20))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, callbackRecordingsGet+strings.TrimSuffix(segment.File, ".mp4"))))
+	}
+
+	var navigation []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("« Назад", callbackRecordingsPage+strconv.Itoa(page-1)))
+	}
+	if page+1 < pages {
+		navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("Вперед »", callbackRecordingsPage+strconv.Itoa(page+1)))
+	}
+	if len(navigation) > 0 {
+		rows = append(rows, navigation)
+	}
+	if query.HasRange {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Вырезать интервал", callbackRecordingsCut)))
+	}
+
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...), nil
+}
+
+// cutRecording writes part of the archive between from and to into target
+func (r *Recorder) cutRecording(from, to time.Time, target string) error {
+	segments, err := r.finishedSegments()
+	if err != nil {
+		return err
+	}
+
+	var parts []Segment
+	for _, segment := range segments {
+		if segment.End.After(from) && segment.Start.Before(to) {
+			parts = append(parts, segment)
+		}
+	}
+	if len(parts) == 0 {
+		return errors.New("в архиве нет записей за этот интервал")
+	}
+
+	list, err := os.CreateTemp("", "cut-*.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(list.Name())
+
+	for _, part := range parts {
+		path, err := filepath.Abs(filepath.Join(r.Dir, part.File))
+		if err != nil {
+			list.Close()
+			return err
+		}
+		fmt.Fprintf(list, "file '%s'\n", strings.ReplaceAll(path, "'", `'\''`))
+	}
+	if err := list.Close(); err != nil {
+		return err
+	}
+
+	offset := from.Sub(parts[0].Start)
+	if offset < 0 {
+		offset = 0
+	}
+	duration := to.Sub(from)
+
+	ctx, cancel := context.WithTimeout(context.Background(), duration+time.Minute)
+	defer cancel()
+
+	return runFFmpeg(ctx,
+		"-f", "concat", "-safe", "0", "-i", list.Name(),
+		"-ss", strconv.FormatFloat(offset.Seconds(), 'f', 3, 64),
+		"-t", strconv.FormatFloat(duration.Seconds(), 'f', 3, 64),
+		"-c", "copy", "-movflags", "+faststart", target)
+}
+
+// sendRecording uploads file to chat, re-encoding it if it's larger than upload limit
+func sendRecording(bot Messenger, chatID int64, path string, duration time.Duration, caption string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if info.Size() > maxUploadSize {
+		dir, err := os.MkdirTemp("", "recording")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		shrunk := filepath.Join(dir, "small.mp4")
+		if err := shrinkClip(path, int(duration.Seconds())+1, shrunk); err != nil {
+			return err
+		}
+		if info, err = os.Stat(shrunk); err != nil {
+			return err
+		}
+		if info.Size() > maxUploadSize {
+			return errors.New("запись слишком большая для отправки, экспортируйте ее в файл")
+		}
+		path = shrunk
+	}
+
+	video := tgbotapi.NewVideoUpload(chatID, path)
+	video.Caption = caption
+	video.Duration = int(duration.Seconds())
+	_, err = bot.Send(video)
+	return err
+}
+
+// handleRecordings processes /recordings [day] [HH:MM-HH:MM] [camera:name] command
+func handleRecordings(bot Messenger, message *tgbotapi.Message, session *Session) {
+	args := strings.TrimSpace(message.CommandArguments())
+
+	query, err := parseRecordingQuery(args, time.Now())
+	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Простите, но "+err.Error()+". Например: /recordings today 14:00-14:10 camera:Коридор")
+		bot.Send(msg)
+		return
+	}
+
+	text, keyboard, err := recordingsPage(sessionServer(session).recorder, query, 0)
+	if err != nil {
+		systemLog.Error("failed to list recordings", "err", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Не удалось прочитать архив записей.")
+		bot.Send(msg)
+		return
+	}
+
+	session.RecordingsQuery = args
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyMarkup = keyboard
+	bot.Send(msg)
+}
+
+// handleRecordingsCallback processes buttons of /recordings list
+func handleRecordingsCallback(bot Messenger, query *tgbotapi.CallbackQuery, session *Session) {
+	chatID := query.Message.Chat.ID
+	recorder := sessionServer(session).recorder
+
+	filter, err := parseRecordingQuery(session.RecordingsQuery, time.Now())
+	if err != nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, "Список устарел, выполните /recordings заново."))
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(query.Data, callbackRecordingsPage):
+		page, _ := strconv.Atoi(strings.TrimPrefix(query.Data, callbackRecordingsPage))
+		text, keyboard, err := recordingsPage(recorder, filter, page)
+		if err != nil {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, "Не удалось прочитать архив записей."))
+			return
+		}
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, ""))
+		edit := tgbotapi.NewEditMessageText(chatID, query.Message.MessageID, text)
+		edit.ReplyMarkup = &keyboard
+		bot.Send(edit)
+
+	case strings.HasPrefix(query.Data, callbackRecordingsGet):
+		file := filepath.Base(strings.TrimPrefix(query.Data, callbackRecordingsGet)) + ".mp4"
+		segments, err := recorder.finishedSegments()
+		if err != nil {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, "Не удалось прочитать архив записей."))
+			return
+		}
+		for _, segment := range segments {
+			if segment.File != file {
+				continue
+			}
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, ""))
+			started := segment.Start.Format("02.01.2006 15:04:05")
+			caption := started + " " + strings.Join(segment.Cameras, ", ")
+			path, duration := filepath.Join(recorder.Dir, file), segment.End.Sub(segment.Start)
+			runAsync(bot, chatID, "Отправляю запись "+started+"...", func() string {
+				if err := sendRecording(bot, chatID, path, duration, caption); err != nil {
+					systemLog.Warn("failed to send recording", "file", file, "err", err)
+					return "Не удалось отправить запись: " + redact(err.Error())
+				}
+				return "Запись " + started + " отправлена."
+			})
+			return
+		}
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, "Запись уже удалена."))
+
+	case query.Data == callbackRecordingsCut:
+		if !filter.HasRange {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, "Интервал не задан."))
+			return
+		}
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, ""))
+		sendCut(bot, chatID, recorder, filter, "")
+	}
+}
+
+// exportPath returns path of the export file in exportDir, only a plain file name is accepted
+func exportPath(name string) (string, error) {
+	if exportDir == "" {
+		return "", errors.New("сохранение в файл не настроено, задайте EXPORT_DIR")
+	}
+	if name == "" || name == "." || strings.Contains(name, "..") || strings.ContainsAny(name, `/\`) {
+		return "", errors.New("укажите только имя файла, без пути")
+	}
+	return filepath.Join(exportDir, name), nil
+}
+
+// sendCut cuts the query range from the archive in the background and sends it to chat or exports to the file.
+// An existing export file is never overwritten.
+func sendCut(bot Messenger, chatID int64, recorder *Recorder, query recordingQuery, export string) {
+	interval := query.From.Format("02.01.2006 15:04") + "–" + query.To.Format("15:04")
+
+	runAsync(bot, chatID, "Вырезаю интервал "+interval+"...", func() string {
+		target := export
+		if target == "" {
+			dir, err := os.MkdirTemp("", "cut")
+			if err != nil {
+				botLog.Error("failed to create temporary directory", "err", err)
+				return "Не удалось вырезать интервал: нет места для временного файла."
+			}
+			defer os.RemoveAll(dir)
+			target = filepath.Join(dir, "cut.mp4")
+		} else {
+			// the file is reserved before ffmpeg, so a file appearing meanwhile is not replaced either
+			file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			if errors.Is(err, os.ErrExist) {
+				return "Файл " + filepath.Base(target) + " уже существует, выберите другое имя."
+			}
+			if err != nil {
+				systemLog.Error("failed to create export file", "path", target, "err", err)
+				return "Не удалось создать файл: " + redact(err.Error())
+			}
+			file.Close()
+		}
+
+		if err := recorder.cutRecording(query.From, query.To, target); err != nil {
+			systemLog.Warn("failed to cut recording", "from", query.From, "to", query.To, "err", err)
+			if export != "" {
+				os.Remove(export)
+			}
+			return "Не удалось вырезать интервал: " + redact(err.Error())
+		}
+
+		if export != "" {
+			return "Интервал сохранен в " + export
+		}
+
+		if err := sendRecording(bot, chatID, target, query.To.Sub(query.From), interval); err != nil {
+			systemLog.Warn("failed to send recording", "err", err)
+			return "Не удалось отправить запись: " + redact(err.Error())
+		}
+		return "Интервал " + interval + " отправлен."
+	})
+}
+
+// handleCut processes /cut [day] HH:MM-HH:MM [file.mp4] command, the interval is saved
+// to the file in exportDir instead of sending to chat when the last argument is an .mp4 name
+func handleCut(bot Messenger, message *tgbotapi.Message, server *StreamServer) {
+	fields := strings.Fields(message.CommandArguments())
+	target := ""
+	if len(fields) > 0 && strings.HasSuffix(strings.ToLower(fields[len(fields)-1]), ".mp4") {
+		path, err := exportPath(fields[len(fields)-1])
+		if err != nil {
+			msg := tgbotapi.NewMessage(message.Chat.ID, "Простите, но "+err.Error()+". Например: /cut 19.10 14:00-14:10 lecture.mp4")
+			bot.Send(msg)
+			return
+		}
+		target = path
+		fields = fields[:len(fields)-1]
+	}
+
+	query, err := parseRecordingQuery(strings.Join(fields, " "), time.Now())
+	if err == nil && !query.HasRange {
+		err = errors.New("нужно указать интервал времени")
+	}
+	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Простите, но "+err.Error()+". Например: /cut today 14:00-14:10 или /cut 19.10 14:00-14:10 lecture.mp4")
+		bot.Send(msg)
+		return
+	}
+
+	sendCut(bot, message.Chat.ID, server.recorder, query, target)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"runtime/debug"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// newCorrelationID returns a short random ID connecting the user message, the log and the crash report
+func newCorrelationID() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// updateSource returns chat, user and description of the update for crash reports
+func updateSource(update tgbotapi.Update) (chatID int64, userID int, description string) {
+	switch {
+	case update.CallbackQuery != nil:
+		if update.CallbackQuery.Message != nil && update.CallbackQuery.Message.Chat != nil {
+			chatID = update.CallbackQuery.Message.Chat.ID
+		}
+		if update.CallbackQuery.From != nil {
+			userID = update.CallbackQuery.From.ID
+		}
+		description = "кнопка " + update.CallbackQuery.Data
+	case update.Message != nil:
+		if update.Message.Chat != nil {
+			chatID = update.Message.Chat.ID
+		}
+		if update.Message.From != nil {
+			userID = update.Message.From.ID
+		}
+		description = "сообщение " + update.Message.Text
+	}
+	return chatID, userID, description
+}
+
+// recoverUpdate stops panic of the update handler, so other updates are processed.
+// The session of the chat is reset, the user is told that the command failed and
+// admins receive the report unless CRASH_REPORTS=0. It must be deferred while the
+// session is locked.
+func recoverUpdate(bot Messenger, update tgbotapi.Update, session *Session) {
+	failure := recover()
+	if failure == nil {
+		return
+	}
+
+	id := newCorrelationID()
+	chatID, userID, description := updateSource(update)
+	stack := string(debug.Stack())
+	botLog.Error("handler panicked",
+		"correlation_id", id,
+		"chat_id", chatID,
+		"user_id", userID,
+		"panic", fmt.Sprint(failure),
+		"stack", stack)
+
+	if update.CallbackQuery != nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+	}
+
+	if session != nil {
+		session.State = StateWork
+		session.PendingCamera = ""
+		session.PendingImport = nil
+	}
+	if chatID != 0 {
+		msg := tgbotapi.NewMessage(chatID, "Простите, при выполнении команды произошла внутренняя ошибка. Диалог сброшен, введите следующую команду.\nКод ошибки: "+id)
+		bot.Send(msg)
+	}
+
+	reportCrash(bot, fmt.Sprintf("Сбой обработчика, код %s\nПользователь %d, чат %d\n%s", id, userID, chatID, description), failure, stack)
+}
+
+// reportCrash sends admins the report of the panic with the header describing where it happened,
+// secrets are redacted. Nothing is sent with CRASH_REPORTS=0.
+func reportCrash(bot Messenger, header string, failure any, stack string) {
+	if os.Getenv("CRASH_REPORTS") == "0" {
+		return
+	}
+	report := fmt.Sprintf("%s\n\nПаника: %v\n\n%s", header, failure, stack)
+	// the beginning of the stack is the most useful part
+	if runes := []rune(redact(report)); len(runes) > maxMessageLength {
+		report = string(runes[:maxMessageLength]) + "..."
+	} else {
+		report = string(runes)
+	}
+	notifyAdmins(bot, report)
+}
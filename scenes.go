@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Scene is a named set of broadcast settings applied at once with /scene,
+// e.g. a lecture is the hall camera with recording and the chatbot on
+type Scene struct {
+	Name string `json:"name"`
+	// Camera is made active, empty keeps the current camera
+	Camera string `json:"camera,omitempty"`
+	// Recording and Chatbot are turned on or off, missing ones are kept as they are
+	Recording *bool `json:"recording,omitempty"`
+	Chatbot   *bool `json:"chatbot,omitempty"`
+}
+
+// sceneStep is an applied change of the scene with the way to undo it
+type sceneStep struct {
+	Description string
+	Undo        func() error
+}
+
+// checkScenes validates scenes of the server configuration
+func checkScenes(scenes []Scene) error {
+	seen := map[string]bool{}
+	for _, scene := range scenes {
+		if scene.Name == "" {
+			return errors.New("scene name is required")
+		}
+		key := strings.ToLower(scene.Name)
+		if seen[key] {
+			return fmt.Errorf("duplicate scene name %q", scene.Name)
+		}
+		seen[key] = true
+		if scene.Camera == "" && scene.Recording == nil && scene.Chatbot == nil {
+			return fmt.Errorf("scene %q changes nothing", scene.Name)
+		}
+	}
+	return nil
+}
+
+// findScene returns the scene of the server by the name in any case
+func findScene(server *StreamServer, name string) (Scene, bool) {
+	for _, scene := range server.Scenes {
+		if strings.EqualFold(scene.Name, name) {
+			return scene, true
+		}
+	}
+	return Scene{}, false
+}
+
+// onOff prints a switch of the scene
+func onOff(value bool) string {
+	if value {
+		return "вкл"
+	}
+	return "выкл"
+}
+
+// describeScene prints the scene in a line
+func describeScene(scene Scene) string {
+	var parts []string
+	if scene.Camera != "" {
+		parts = append(parts, "камера "+scene.Camera)
+	}
+	if scene.Recording != nil {
+		parts = append(parts, "запись "+onOff(*scene.Recording))
+	}
+	if scene.Chatbot != nil {
+		parts = append(parts, "чат-бот "+onOff(*scene.Chatbot))
+	}
+	return scene.Name + ": " + strings.Join(parts, ", ")
+}
+
+// startRecording starts recording of the broadcast from the active camera
+func (s *StreamServer) startRecording() error {
+	streamURL := s.getStreamURL()
+	if streamURL == "" {
+		return errors.New("StreamServer didn't return stream URL")
+	}
+	cam, err := s.getActive()
+	if err != nil {
+		return err
+	}
+	return s.recorder.Start(streamURL, cam.Name)
+}
+
+// stepError describes failed step, errors of StreamServer requests are explained
+func stepError(err error) string {
+	var schemaErr *schemaError
+	var rejectedErr *rejectedError
+	var notApplied *notAppliedError
+	if errors.As(err, &schemaErr) || errors.As(err, &rejectedErr) || errors.As(err, &notApplied) || errors.Is(err, errBreakerOpen) {
+		return changeResult(err, "")
+	}
+	return redact(err.Error())
+}
+
+// applyScene switches the camera, recording and the chatbot as the scene says.
+// When a step fails, the applied steps are undone in reverse order, so the broadcast
+// is left as it was. Starts and stops of the server are refused with busyMessage while the
+// scene is applied, and so is the scene while they run.
+// Returns report for the user.
+func (s *StreamServer) applyScene(scene Scene) string {
+	if !s.lifecycle.TryLock() {
+		return busyMessage(s)
+	}
+	defer s.lifecycle.Unlock()
+
+	if !s.awake() {
+		return "Сцены применяются только к работающей системе. Пожалуйста, выполните команду /awake для запуска."
+	}
+
+	var applied []sceneStep
+	fail := func(step string, err error) string {
+		s.log.Error("failed to apply scene", "scene", scene.Name, "step", step, "err", err)
+		report := "Не удалось применить сцену " + scene.Name + ": " + step + " - " + stepError(err) + "\n"
+		if len(applied) == 0 {
+			return report + "Ничего не изменено."
+		}
+
+		report += "\nОтмена выполненных шагов:\n"
+		for i := len(applied) - 1; i >= 0; i-- {
+			if err := applied[i].Undo(); err != nil {
+				s.log.Error("failed to undo scene step", "scene", scene.Name, "step", applied[i].Description, "err", err)
+				report += "  " + applied[i].Description + ": не удалось отменить - " + stepError(err) + "\n"
+			} else {
+				report += "  " + applied[i].Description + ": отменено\n"
+			}
+		}
+		return report
+	}
+
+	if scene.Camera != "" {
+		cam, err := s.getActive()
+		if err != nil {
+			return fail("проверка активной камеры", err)
+		}
+		if cam.Name != scene.Camera {
+			if err := s.selectCamera(scene.Camera); err != nil {
+				return fail("переключение на камеру "+scene.Camera, err)
+			}
+			previous := cam.Name
+			applied = append(applied, sceneStep{
+				Description: "переключение на камеру " + scene.Camera,
+				Undo: func() error {
+					// StreamServer can't leave the broadcast without a camera
+					if previous == "" {
+						return errors.New("no camera was active before")
+					}
+					return s.selectCamera(previous)
+				}})
+		}
+	}
+
+	if scene.Recording != nil && *scene.Recording != s.recorder.IsRunning() {
+		if *scene.Recording {
+			if err := s.startRecording(); err != nil {
+				return fail("запуск записи", err)
+			}
+			applied = append(applied, sceneStep{
+				Description: "запуск записи",
+				Undo: func() error {
+					s.recorder.Stop()
+					return nil
+				}})
+		} else {
+			s.recorder.Stop()
+			applied = append(applied, sceneStep{
+				Description: "остановка записи",
+				Undo:        s.startRecording})
+		}
+	}
+
+	if scene.Chatbot != nil {
+		running, err := s.supervisor.IsRunning(s.chatbotProcess)
+		if err != nil {
+			return fail("проверка чат-бота", err)
+		}
+		switch {
+		case *scene.Chatbot && !running:
+			if err := s.startChatbotLocked(); err != nil {
+				return fail("запуск чат-бота", err)
+			}
+			applied = append(applied, sceneStep{Description: "запуск чат-бота", Undo: s.stopChatbotLocked})
+		case !*scene.Chatbot && running:
+			if err := s.stopChatbotLocked(); err != nil {
+				return fail("остановка чат-бота", err)
+			}
+			applied = append(applied, sceneStep{Description: "остановка чат-бота", Undo: s.startChatbotLocked})
+		}
+	}
+
+	s.log.Info("scene applied", "scene", scene.Name, "steps", len(applied))
+	if len(applied) == 0 {
+		return "Сцена " + scene.Name + " уже действует, ничего не изменено."
+	}
+	report := "Сцена " + scene.Name + " применена:\n"
+	for _, step := range applied {
+		report += "  " + step.Description + "\n"
+	}
+	return report
+}
+
+// handleScene processes /scene [name] command: lists scenes of the server or applies one
+func handleScene(bot Messenger, message *tgbotapi.Message, server *StreamServer) {
+	name := strings.TrimSpace(message.CommandArguments())
+	if name == "" {
+		reply := ""
+		if len(server.Scenes) == 0 {
+			reply = "Для сервера " + server.Name + " сцены не настроены. Они задаются в файле конфигурации."
+		} else {
+			reply = "Сцены сервера " + server.Name + ":\n"
+			for _, scene := range server.Scenes {
+				reply += describeScene(scene) + "\n"
+			}
+			reply += "\nДля применения введите /scene имя, например: /scene " + server.Scenes[0].Name
+		}
+		msg := tgbotapi.NewMessage(message.Chat.ID, reply)
+		bot.Send(msg)
+		return
+	}
+
+	scene, ok := findScene(server, name)
+	if !ok {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Простите, но сцены \""+name+"\" нет. Список сцен: /scene")
+		bot.Send(msg)
+		return
+	}
+
+	runAsync(bot, message.Chat.ID, "Применяю сцену "+scene.Name+"...", func() string {
+		return server.applyScene(scene)
+	})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/valyala/fasthttp"
+)
+
+// configFile is a path to the bot configuration, can be set with CONFIG_FILE
+var configFile = "config.json"
+
+// Config is the bot configuration file
+type Config struct {
+	Servers []ServerConfig `json:"servers"`
+	Monitor *MonitorConfig `json:"monitor,omitempty"`
+}
+
+// ServerConfig describes one StreamServer instance and its binaries
+type ServerConfig struct {
+	Name          string `json:"name"`
+	Address       string `json:"address"`
+	ServerBinary  string `json:"server_binary"`
+	ChatbotBinary string `json:"chatbot_binary"`
+	// Backend runs the binaries: "local" (default), "ssh", "systemd" or "docker"
+	Backend string `json:"backend"`
+	// ServerUnit and ChatbotUnit are systemd units of the binaries for the systemd backend
+	ServerUnit  string `json:"server_unit,omitempty"`
+	ChatbotUnit string `json:"chatbot_unit,omitempty"`
+	// LogDir keeps output of the binaries, "logs" locally and "/tmp" over SSH by default
+	LogDir string        `json:"log_dir"`
+	SSH    *SSHConfig    `json:"ssh,omitempty"`
+	Docker *DockerConfig `json:"docker,omitempty"`
+	// Scenes are applied with /scene
+	Scenes []Scene `json:"scenes,omitempty"`
+}
+
+// defaultServer is used when configuration file doesn't exist
+var defaultServer = ServerConfig{
+	Name:          "lab",
+	Address:       "localhost:8081",
+	ServerBinary:  "/home/anton/Radium/StreamServer/StreamServer",
+	ChatbotBinary: "/home/anton/Radium/LabYoutubeChatbot/LabYoutubeChatbot"}
+
+// StreamServer is a client of one StreamServer instance with its state
+type StreamServer struct {
+	ServerConfig
+
+	// mu guards the state below, handlers of different chats run concurrently
+	mu      sync.Mutex
+	isAwake bool
+	// chatbotWanted means the chatbot was started by the bot and its exit is a crash
+	chatbotWanted bool
+	cameras       []CameraData
+	// sources keeps connection data of cameras added by the bot, StreamServer doesn't report it
+	sources map[string]AddCameraData
+	// metadata keeps descriptions of cameras set with /camera
+	metadata map[string]*CameraMeta
+
+	// lifecycle allows only one start or stop of the processes at a time
+	lifecycle sync.Mutex
+
+	recorder *Recorder
+	log      *slog.Logger
+
+	supervisor     Supervisor
+	streamProcess  Process
+	chatbotProcess Process
+
+	client  *fasthttp.Client
+	breaker *circuitBreaker
+}
+
+// errBusy means another start or stop of the server is in progress
+var errBusy = errors.New("another start or stop is in progress")
+
+// busyMessage tells the user that the server is busy with another start or stop
+func busyMessage(server *StreamServer) string {
+	return "Сервер " + server.Name + " сейчас запускается или останавливается по другой команде. Дождитесь ее завершения и повторите."
+}
+
+func (s *StreamServer) awake() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isAwake
+}
+
+func (s *StreamServer) setAwake(awake bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.isAwake = awake
+}
+
+func (s *StreamServer) wantsChatbot() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.chatbotWanted
+}
+
+func (s *StreamServer) setChatbotWanted(wanted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chatbotWanted = wanted
+}
+
+// cachedCameras returns cameras received by the last /getcameras
+func (s *StreamServer) cachedCameras() []CameraData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]CameraData{}, s.cameras...)
+}
+
+func (s *StreamServer) setCameras(cameras []CameraData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cameras = cameras
+}
+
+// servers are managed StreamServer instances, the first one is selected by default
+var servers []*StreamServer
+
+// loadConfig reads configFile, missing file means single default server
+func loadConfig() (Config, error) {
+	payload, err := os.ReadFile(configFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return Config{Servers: []ServerConfig{defaultServer}}, nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(payload, &config); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// setupServers creates clients of the configured servers
+func setupServers(config Config) error {
+	if len(config.Servers) == 0 {
+		return errors.New("no servers configured")
+	}
+
+	servers = nil
+	for _, serverConfig := range config.Servers {
+		if serverConfig.Name == "" || serverConfig.Address == "" {
+			return errors.New("server name and address are required")
+		}
+		if findServer(serverConfig.Name) != nil {
+			return fmt.Errorf("duplicate server name %q", serverConfig.Name)
+		}
+
+		supervisor, err := newSupervisor(serverConfig)
+		if err != nil {
+			return fmt.Errorf("server %q: %v", serverConfig.Name, err)
+		}
+		if err := checkScenes(serverConfig.Scenes); err != nil {
+			return fmt.Errorf("server %q: %v", serverConfig.Name, err)
+		}
+
+		server := &StreamServer{
+			ServerConfig:   serverConfig,
+			sources:        map[string]AddCameraData{},
+			metadata:       map[string]*CameraMeta{},
+			recorder:       newRecorder(serverConfig.Name),
+			log:            serverLog.With("server", serverConfig.Name),
+			supervisor:     supervisor,
+			streamProcess:  Process{Name: streamProcessName, Binary: serverConfig.ServerBinary, Unit: serverConfig.ServerUnit},
+			chatbotProcess: Process{Name: chatbotProcessName, Binary: serverConfig.ChatbotBinary, Unit: serverConfig.ChatbotUnit}}
+		server.setupClient()
+		servers = append(servers, server)
+	}
+	return nil
+}
+
+// findServer returns server by name or nil
+func findServer(name string) *StreamServer {
+	for _, server := range servers {
+		if server.Name == name {
+			return server
+		}
+	}
+	return nil
+}
+
+// sessionServer returns server selected in the session
+func sessionServer(session *Session) *StreamServer {
+	if server := findServer(session.Server); server != nil {
+		return server
+	}
+	return servers[0]
+}
+
+// handleServer processes /server [name] command
+func handleServer(bot Messenger, message *tgbotapi.Message, session *Session) {
+	name := strings.TrimSpace(message.CommandArguments())
+
+	if name != "" {
+		server := findServer(name)
+		if server == nil {
+			msg := tgbotapi.NewMessage(message.Chat.ID, "Простите, но сервера с таким именем не существует.")
+			bot.Send(msg)
+			return
+		}
+		session.Server = server.Name
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Выбран сервер "+server.Name+". Все команды будут выполняться на нем.")
+		bot.Send(msg)
+		return
+	}
+
+	current := sessionServer(session)
+	reply := "Список серверов:\n"
+	for _, server := range servers {
+		line := server.Name + " (" + server.Address + ")"
+		if server == current {
+			line += " - выбран"
+		}
+		reply += line + "\n"
+	}
+	reply += "\nДля выбора введите /server имя, например: /server " + servers[0].Name
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, reply)
+	bot.Send(msg)
+}
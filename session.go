@@ -0,0 +1,83 @@
+package main
+
+import "sync"
+
+// Session keeps dialog state of one chat
+type Session struct {
+	// mu is held while an update of the chat is handled
+	mu sync.Mutex
+
+	// Server is the name of the selected StreamServer
+	Server    string        `json:"server,omitempty"`
+	State     State         `json:"state"`
+	NewCamera AddCameraData `json:"new_camera"`
+	// PendingCamera waits for confirmation after preview
+	PendingCamera string `json:"pending_camera,omitempty"`
+	// PendingImport waits for confirmation of /import
+	PendingImport *configDocument `json:"pending_import,omitempty"`
+	// RecordingsQuery is the filter of the last /recordings list
+	RecordingsQuery string `json:"recordings_query,omitempty"`
+}
+
+// UserSettings keeps preferences of an administrator
+type UserSettings struct {
+	SkipPreview bool `json:"skip_preview"`
+}
+
+var (
+	// sessionsMu guards the sessions and settings maps and the settings
+	sessionsMu sync.Mutex
+	sessions   = map[int64]*Session{}
+	settings   = map[int]*UserSettings{}
+)
+
+// getSession returns session of the chat, creating a new one if needed
+func getSession(chatID int64) *Session {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	session, ok := sessions[chatID]
+	if !ok {
+		session = &Session{State: StateWork}
+		sessions[chatID] = session
+	}
+	return session
+}
+
+// stored returns copy of the session for saving, new fields must be added here
+func (s *Session) stored() *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &Session{
+		Server:          s.Server,
+		State:           s.State,
+		NewCamera:       s.NewCamera,
+		PendingCamera:   s.PendingCamera,
+		PendingImport:   s.PendingImport,
+		RecordingsQuery: s.RecordingsQuery}
+}
+
+// getSettings returns copy of settings of the user
+func getSettings(userID int) UserSettings {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	if userSettings, ok := settings[userID]; ok {
+		return *userSettings
+	}
+	return UserSettings{}
+}
+
+// updateSettings changes settings of the user, creating default ones if needed
+func updateSettings(userID int, update func(*UserSettings)) UserSettings {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	userSettings, ok := settings[userID]
+	if !ok {
+		userSettings = &UserSettings{}
+		settings[userID] = userSettings
+	}
+	update(userSettings)
+	return *userSettings
+}
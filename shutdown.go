@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// shutdownTimeout limits time for finishing the current handler and cleanup
+const shutdownTimeout = 15 * time.Second
+
+// notifyAdmins sends message to every administrator
+func notifyAdmins(bot Messenger, message string) {
+	for _, id := range adminID {
+		msg := tgbotapi.NewMessage(int64(id), message)
+		if _, err := bot.Send(msg); err != nil {
+			botLog.Warn("failed to notify admin", "user_id", id, "err", err)
+		}
+	}
+}
+
+// shutdown stops receiving updates, waits for the current handlers and tasks to finish,
+// notifies admins and saves bot state. If HALT_ON_EXIT=1, managed processes are stopped too.
+// When it takes longer than shutdownTimeout, the bot exits anyway.
+func shutdown(bot Messenger, handlersDone <-chan struct{}) {
+	botLog.Info("shutting down")
+	deadline := time.After(shutdownTimeout)
+
+	bot.StopReceivingUpdates()
+
+	select {
+	case <-handlersDone:
+	case <-deadline:
+		botLog.Error("handlers did not finish in time, exiting")
+		os.Exit(1)
+	}
+
+	// long operations started by handlers edit their messages, so they are awaited too
+	tasksDone := make(chan struct{})
+	go func() {
+		defer close(tasksDone)
+		tasks.Wait()
+	}()
+	select {
+	case <-tasksDone:
+	case <-deadline:
+		botLog.Error("background tasks did not finish in time, exiting")
+		os.Exit(1)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+
+		message := "Бот отключается. Незавершенные действия можно будет продолжить после перезапуска."
+		for _, server := range servers {
+			if os.Getenv("HALT_ON_EXIT") == "1" && server.awake() {
+				err := server.haltSystem()
+				switch {
+				case err == nil:
+					message += "\nСистема трансляций на сервере " + server.Name + " остановлена."
+				case errors.Is(err, errBusy):
+					server.log.Warn("failed to halt system", "err", err)
+					message += "\n" + busyMessage(server)
+				default:
+					server.log.Warn("failed to halt system", "err", err)
+					message += "\nНе удалось остановить систему трансляций на сервере " + server.Name + ": " + redact(err.Error())
+				}
+			}
+			server.recorder.Stop()
+		}
+		notifyAdmins(bot, message)
+
+		if err := saveState(); err != nil {
+			botLog.Error("failed to save state", "err", err)
+		}
+	}()
+
+	select {
+	case <-finished:
+		botLog.Info("shutdown complete")
+	case <-deadline:
+		botLog.Error("cleanup did not finish in time, exiting")
+		os.Exit(1)
+	}
+}
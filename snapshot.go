@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// snapshotTimeout limits time of grabbing one frame
+	snapshotTimeout = 15 * time.Second
+	// snapshotCacheTTL is how long a frame is reused instead of grabbing a new one
+	snapshotCacheTTL = 30 * time.Second
+)
+
+var errSnapshotTimeout = errors.New("camera did not return a frame in time")
+
+// snapshot is a cached still frame of a camera
+type snapshot struct {
+	Data  []byte
+	Taken time.Time
+}
+
+// snapshotCall is a grab of the frame in progress, the callers asking for the same camera wait for it
+type snapshotCall struct {
+	done  chan struct{}
+	frame []byte
+	err   error
+}
+
+var (
+	snapshotMu       sync.Mutex
+	snapshotCache    = map[string]snapshot{}
+	snapshotInFlight = map[string]*snapshotCall{}
+)
+
+// cameraSource returns connection data of the camera added by the bot or found in presets
+func (s *StreamServer) cameraSource(name string) (AddCameraData, bool) {
+	s.mu.Lock()
+	source, ok := s.sources[name]
+	s.mu.Unlock()
+	if ok {
+		return source, true
+	}
+	for _, preset := range presets {
+		if preset.Name == name {
+			return preset, true
+		}
+	}
+	return AddCameraData{}, false
+}
+
+// ffmpegInput returns ffmpeg input arguments for the camera:
+// type 0 is a V4L2 device, 1 and 2 are RTSP cameras over TCP and UDP
+func ffmpegInput(source AddCameraData) []string {
+	switch source.Type {
+	case 0:
+		return []string{"-f", "v4l2", "-i", source.URL}
+	case 2:
+		return []string{"-rtsp_transport", "udp", "-i", source.URL}
+	default:
+		return []string{"-rtsp_transport", "tcp", "-i", source.URL}
+	}
+}
+
+// grabSnapshot takes one JPEG frame from the camera with ffmpeg
+func grabSnapshot(source AddCameraData) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	args = append(args, ffmpegInput(source)...)
+	args = append(args, "-frames:v", "1", "-f", "image2", "-c:v", "mjpeg", "pipe:1")
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+
+	frame, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, errSnapshotTimeout
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if len(frame) == 0 {
+		return nil, errors.New("ffmpeg returned empty frame")
+	}
+	return frame, nil
+}
+
+// getSnapshot returns cached frame of the camera or grabs a new one.
+// Concurrent requests for the same camera share one ffmpeg run.
+func (s *StreamServer) getSnapshot(source AddCameraData) ([]byte, error) {
+	key := s.Name + "/" + source.Name
+	snapshotMu.Lock()
+	if cached, ok := snapshotCache[key]; ok && time.Since(cached.Taken) < snapshotCacheTTL {
+		snapshotMu.Unlock()
+		return cached.Data, nil
+	}
+	if call, ok := snapshotInFlight[key]; ok {
+		snapshotMu.Unlock()
+		<-call.done
+		return call.frame, call.err
+	}
+	call := &snapshotCall{done: make(chan struct{})}
+	snapshotInFlight[key] = call
+	snapshotMu.Unlock()
+
+	call.frame, call.err = grabSnapshot(source)
+	if call.err != nil {
+		s.log.Warn("failed to grab snapshot", "camera", source.Name, "url", source.URL, "err", call.err)
+	}
+
+	snapshotMu.Lock()
+	if call.err == nil {
+		snapshotCache[key] = snapshot{
+			Data:  call.frame,
+			Taken: time.Now()}
+	}
+	delete(snapshotInFlight, key)
+	snapshotMu.Unlock()
+	close(call.done)
+	return call.frame, call.err
+}
+
+// handleSnapshot processes /snapshot [camera] command on the server
+func handleSnapshot(bot Messenger, message *tgbotapi.Message, server *StreamServer) {
+	if !server.awake() {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Важно - настройка камер невозможна при выключенной системе. Пожалуйста, выполните команду /awake для запуска.\n")
+		bot.Send(msg)
+		return
+	}
+
+	name := strings.TrimSpace(message.CommandArguments())
+	if name == "" {
+		cam, err := server.getActive()
+		if err != nil {
+			msg := tgbotapi.NewMessage(message.Chat.ID, serverError(err))
+			bot.Send(msg)
+			return
+		}
+		if cam.Name == "" {
+			msg := tgbotapi.NewMessage(message.Chat.ID, "Сейчас ни одна камера не работает. Укажите имя камеры, например: /snapshot Коридор")
+			bot.Send(msg)
+			return
+		}
+		name = cam.Name
+	}
+
+	source, ok := server.cameraSource(name)
+	if !ok {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Строка подключения камеры \""+name+"\" неизвестна. Снимок доступен только для камер, добавленных через бота.")
+		bot.Send(msg)
+		return
+	}
+
+	frame, err := server.getSnapshot(source)
+	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Не удалось получить снимок с камеры \""+name+"\": "+redact(err.Error()))
+		bot.Send(msg)
+		return
+	}
+
+	photo := tgbotapi.NewPhotoUpload(message.Chat.ID, tgbotapi.FileBytes{
+		Name:  "snapshot.jpg",
+		Bytes: frame})
+	photo.Caption = name
+	if _, err := bot.Send(photo); err != nil {
+		botLog.Error("failed to send snapshot", "camera", name, "err", err)
+	}
+}
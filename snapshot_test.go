@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// slowFFmpeg puts ffmpeg on PATH that grabs a frame in 300 ms and logs each run to the returned file,
+// cameras with "broken" in the URL fail
+func slowFFmpeg(t *testing.T) string {
+	dir := t.TempDir()
+	grabs := filepath.Join(dir, "grabs")
+	script := `#!/bin/sh
+echo "$*" >> "` + grabs + `"
+sleep 0.3
+case "$*" in
+*broken*) echo "connection refused" >&2; exit 1 ;;
+esac
+printf 'JPEG'
+`
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return grabs
+}
+
+// countGrabs returns the number of ffmpeg runs since the previous count
+func countGrabs(t *testing.T, grabs string) int {
+	t.Helper()
+	payload, err := os.ReadFile(grabs)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(grabs)
+	return strings.Count(string(payload), "\n")
+}
+
+// snapshotsAtOnce requests the frame of the camera from several goroutines and returns the results
+func snapshotsAtOnce(server *StreamServer, source AddCameraData) ([]string, []error) {
+	const callers = 5
+	frames, errs := make([]string, callers), make([]error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			frame, err := server.getSnapshot(source)
+			frames[i], errs[i] = string(frame), err
+		}(i)
+	}
+	wg.Wait()
+	return frames, errs
+}
+
+func TestSnapshotSingleFlight(t *testing.T) {
+	grabs := slowFFmpeg(t)
+	b := newTestBot(t)
+
+	frames, errs := snapshotsAtOnce(b.server, presets[0])
+	for i := range frames {
+		if frames[i] != "JPEG" || errs[i] != nil {
+			t.Errorf("caller %d got %q, %v", i, frames[i], errs[i])
+		}
+	}
+	if n := countGrabs(t, grabs); n != 1 {
+		t.Errorf("ffmpeg ran %d times for concurrent requests, want 1", n)
+	}
+
+	// the cached frame is reused, another camera is grabbed separately
+	if frame, err := b.server.getSnapshot(presets[0]); string(frame) != "JPEG" || err != nil {
+		t.Errorf("cached frame is %q, %v", frame, err)
+	}
+	if n := countGrabs(t, grabs); n != 0 {
+		t.Errorf("ffmpeg ran %d times for cached frame", n)
+	}
+	if _, err := b.server.getSnapshot(presets[1]); err != nil {
+		t.Error(err)
+	}
+	if n := countGrabs(t, grabs); n != 1 {
+		t.Errorf("ffmpeg ran %d times for another camera, want 1", n)
+	}
+
+	// a failure is shared by the waiting callers and not cached
+	broken := AddCameraData{Name: "Сломанная", Type: 1, URL: "rtsp://broken/stream"}
+	_, errs = snapshotsAtOnce(b.server, broken)
+	for i, err := range errs {
+		if err == nil || !strings.Contains(err.Error(), "connection refused") {
+			t.Errorf("caller %d got error %v", i, err)
+		}
+	}
+	if n := countGrabs(t, grabs); n != 1 {
+		t.Errorf("ffmpeg ran %d times for concurrent failing requests, want 1", n)
+	}
+	b.server.getSnapshot(broken)
+	if n := countGrabs(t, grabs); n != 1 {
+		t.Errorf("ffmpeg ran %d times after failure, want 1", n)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHConfig describes connection to a remote host running StreamServer
+type SSHConfig struct {
+	// Host is host:port of the SSH server, port 22 is used if omitted
+	Host string `json:"host"`
+	User string `json:"user"`
+	// KeyFile is a private key for authentication
+	KeyFile string `json:"key_file"`
+	// KnownHosts is a file with host keys, ~/.ssh/known_hosts by default
+	KnownHosts string `json:"known_hosts"`
+}
+
+// sshSupervisor runs processes on a remote host through SSH
+type sshSupervisor struct {
+	server string
+	host   string
+	logDir string
+	config *ssh.ClientConfig
+}
+
+// newSSHSupervisor prepares SSH client configuration with key-based authentication
+func newSSHSupervisor(config ServerConfig) (*sshSupervisor, error) {
+	if config.SSH == nil || config.SSH.Host == "" || config.SSH.User == "" || config.SSH.KeyFile == "" {
+		return nil, errors.New("ssh backend requires host, user and key_file")
+	}
+
+	key, err := os.ReadFile(config.SSH.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", config.SSH.KeyFile, err)
+	}
+
+	knownHosts := config.SSH.KnownHosts
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	host := config.SSH.Host
+	if !strings.Contains(host, ":") {
+		host += ":22"
+	}
+
+	logDir := config.LogDir
+	if logDir == "" {
+		logDir = "/tmp"
+	}
+
+	return &sshSupervisor{
+		server: config.Name,
+		host:   host,
+		logDir: logDir,
+		config: &ssh.ClientConfig{
+			User:            config.SSH.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         10 * time.Second}}, nil
+}
+
+// shellQuote makes the value safe to use in a remote shell command
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// run executes command on the remote host and returns its output
+func (r *sshSupervisor) run(command string) (string, error) {
+	client, err := ssh.Dial("tcp", r.host, r.config)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	output, err := session.CombinedOutput(command)
+	return string(output), err
+}
+
+func (r *sshSupervisor) logPath(process Process) string {
+	return r.logDir + "/" + r.server + "-" + process.Name + ".log"
+}
+
+func (r *sshSupervisor) Start(process Process) error {
+	// Output is redirected, so the session doesn't wait for the background process.
+	// Exit status is appended to the log, because the bot can't wait for the remote process.
+	script := shellQuote(process.Binary) + "; echo \"" + process.Name + ": exit status $?\""
+	command := "nohup sh -c " + shellQuote(script) + " >> " + shellQuote(r.logPath(process)) + " 2>&1 < /dev/null &"
+	output, err := r.run(command)
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// processPattern matches the whole command line of the binary started by Start, so neither
+// the sh wrapper writing its exit status nor e.g. an editor of the binary is matched
+func processPattern(process Process) string {
+	return shellQuote(regexp.QuoteMeta(process.Binary))
+}
+
+// Stop kills the binary, pkill exits with 1 when it is already stopped
+func (r *sshSupervisor) Stop(process Process) error {
+	output, err := r.run("pkill -x -f " + processPattern(process))
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitStatus() == 1 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(output))
+	}
+	return nil
+}
+
+func (r *sshSupervisor) IsRunning(process Process) (bool, error) {
+	_, err := r.run("pgrep -x -f " + processPattern(process))
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitStatus() == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ServiceInfo finds the newest matching process on the remote host and its running time
+func (r *sshSupervisor) ServiceInfo(process Process) (ServiceInfo, error) {
+	pid, err := r.run("pgrep -n -x -f " + processPattern(process))
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitStatus() == 1 {
+		return ServiceInfo{State: "inactive", Restarts: -1}, nil
+	}
+	if err != nil {
+		return ServiceInfo{}, err
+	}
+	elapsed, err := r.run("ps -o etimes= -p " + shellQuote(strings.TrimSpace(pid)))
+	if err != nil {
+		return ServiceInfo{}, fmt.Errorf("%v: %s", err, strings.TrimSpace(elapsed))
+	}
+	return parseProcessInfo(pid, elapsed)
+}
+
+func (r *sshSupervisor) Logs(process Process, lines int) (string, error) {
+	output, err := r.run("tail -n " + strconv.Itoa(lines) + " " + shellQuote(r.logPath(process)))
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(output))
+	}
+	return output, nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshTestServer is an in-process SSH server that records exec requests
+// and answers them with output and exit status of the handler
+type sshTestServer struct {
+	address   string
+	hostKey   ssh.PublicKey
+	clientKey ed25519.PrivateKey
+
+	mu       sync.Mutex
+	commands []string
+	handler  func(command string) (string, uint32)
+}
+
+func newSSHTestServer(t *testing.T) *sshTestServer {
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPublic, clientPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := ssh.NewPublicKey(clientPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() != "stream" || !bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		}}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &sshTestServer{
+		address:   listener.Addr().String(),
+		hostKey:   hostSigner.PublicKey(),
+		clientKey: clientPrivate,
+		handler:   func(string) (string, uint32) { return "", 0 }}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+// serve runs exec requests of one connection
+func (s *sshTestServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer channel.Close()
+			for request := range channelRequests {
+				if request.Type != "exec" {
+					request.Reply(false, nil)
+					continue
+				}
+				var exec struct{ Command string }
+				if err := ssh.Unmarshal(request.Payload, &exec); err != nil {
+					request.Reply(false, nil)
+					continue
+				}
+				request.Reply(true, nil)
+
+				s.mu.Lock()
+				s.commands = append(s.commands, exec.Command)
+				handler := s.handler
+				s.mu.Unlock()
+
+				output, status := handler(exec.Command)
+				channel.Write([]byte(output))
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				return
+			}
+		}()
+	}
+}
+
+// answer sets output and exit status of the following commands
+func (s *sshTestServer) answer(output string, status uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = func(string) (string, uint32) { return output, status }
+}
+
+// takeCommands returns commands executed since the previous take
+func (s *sshTestServer) takeCommands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	commands := s.commands
+	s.commands = nil
+	return commands
+}
+
+// config returns server configuration with the client key and known_hosts listing hostKey
+func (s *sshTestServer) config(t *testing.T, hostKey ssh.PublicKey) ServerConfig {
+	dir := t.TempDir()
+	block, err := ssh.MarshalPrivateKey(s.clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	knownHosts := filepath.Join(dir, "known_hosts")
+	line := ""
+	if hostKey != nil {
+		line = knownhosts.Line([]string{knownhosts.Normalize(s.address)}, hostKey) + "\n"
+	}
+	if err := os.WriteFile(knownHosts, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return ServerConfig{
+		Name:    "лаборатория 1",
+		Backend: "ssh",
+		LogDir:  "/var/log/it's mine",
+		SSH:     &SSHConfig{Host: s.address, User: "stream", KeyFile: keyFile, KnownHosts: knownHosts}}
+}
+
+func TestSSHSupervisorCommands(t *testing.T) {
+	server := newSSHTestServer(t)
+	supervisor, err := newSSHSupervisor(server.config(t, server.hostKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	process := Process{Name: "stream", Binary: "/opt/Stream Server/it's \"live\".bin"}
+
+	if err := supervisor.Start(process); err != nil {
+		t.Fatal(err)
+	}
+	if err := supervisor.Stop(process); err != nil {
+		t.Fatal(err)
+	}
+	running, err := supervisor.IsRunning(process)
+	if err != nil || !running {
+		t.Errorf("IsRunning is %v, %v, want running", running, err)
+	}
+
+	want := []string{
+		`nohup sh -c ''\''/opt/Stream Server/it'\''\'\'''\''s "live".bin'\''; echo "stream: exit status $?"' >> '/var/log/it'\''s mine/лаборатория 1-stream.log' 2>&1 < /dev/null &`,
+		`pkill -x -f '/opt/Stream Server/it'\''s "live"\.bin'`,
+		`pgrep -x -f '/opt/Stream Server/it'\''s "live"\.bin'`}
+	if commands := server.takeCommands(); !reflect.DeepEqual(commands, want) {
+		t.Errorf("remote commands:\n%s\nwant:\n%s", strings.Join(commands, "\n"), strings.Join(want, "\n"))
+	}
+
+	// pgrep exits with 1 when nothing matches and with 2 on errors
+	server.answer("", 1)
+	if running, err := supervisor.IsRunning(process); err != nil || running {
+		t.Errorf("IsRunning is %v, %v, want stopped", running, err)
+	}
+	server.answer("pgrep: invalid pattern\n", 2)
+	if _, err := supervisor.IsRunning(process); err == nil {
+		t.Error("IsRunning succeeded on pgrep error")
+	}
+	// pkill exits with 1 when the process is already stopped
+	server.answer("", 1)
+	if err := supervisor.Stop(process); err != nil {
+		t.Errorf("Stop of stopped process failed: %v", err)
+	}
+	server.answer("pkill: permission denied\n", 3)
+	if err := supervisor.Stop(process); err == nil || !strings.Contains(err.Error(), "pkill: permission denied") {
+		t.Errorf("Stop error is %v, want output of pkill", err)
+	}
+}
+
+func TestSSHHostKeyChecking(t *testing.T) {
+	server := newSSHTestServer(t)
+	otherPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ssh.NewPublicKey(otherPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		hostKey ssh.PublicKey
+		want    string
+	}{
+		{"unknown host", nil, "key is unknown"},
+		{"changed host key", otherKey, "key mismatch"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			supervisor, err := newSSHSupervisor(server.config(t, test.hostKey))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = supervisor.Start(Process{Name: "stream", Binary: "/opt/stream"})
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Start error is %v, want %q", err, test.want)
+			}
+			if commands := server.takeCommands(); len(commands) > 0 {
+				t.Errorf("commands %v are run on unverified host", commands)
+			}
+		})
+	}
+
+	config := server.config(t, server.hostKey)
+	config.SSH.KnownHosts = filepath.Join(t.TempDir(), "missing")
+	if _, err := newSSHSupervisor(config); err == nil {
+		t.Error("supervisor is created without known_hosts")
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	values := []string{"", "plain", "two words", "it's", `"double"`, "$HOME `id` $(id); rm -rf /", "'", "a\nb", "''\\'"}
+	for _, value := range values {
+		output, err := exec.Command("sh", "-c", "printf %s "+shellQuote(value)).Output()
+		if err != nil {
+			t.Fatalf("%q: %v", value, err)
+		}
+		if string(output) != value {
+			t.Errorf("shell reads %q quoted as %s, want %q", output, shellQuote(value), value)
+		}
+	}
+
+	if quoted := shellQuote("it's"); quoted != `'it'\''s'` {
+		t.Errorf("shellQuote(it's) is %s", quoted)
+	}
+}
+
+func TestProcessPattern(t *testing.T) {
+	pattern := regexp.QuoteMeta("/opt/stream.bin")
+	lines := map[string]bool{
+		"/opt/stream.bin": true,
+		`sh -c /opt/stream.bin; echo "stream: exit status $?"`: false,
+		"vim /opt/stream.bin": false,
+		"/opt/streamXbin":     false,
+		"/opt/stream.bin.old": false,
+	}
+	for line, want := range lines {
+		// pgrep and pkill use extended regular expressions as grep -E
+		cmd := exec.Command("grep", "-x", "-E", pattern)
+		cmd.Stdin = strings.NewReader(line + "\n")
+		if matched := cmd.Run() == nil; matched != want {
+			t.Errorf("pattern %s matches %q: %v, want %v", pattern, line, matched, want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = ""
+
+// startTime is the time the bot was started
+var startTime = time.Now()
+
+// botVersion returns build version, VCS revision or "dev"
+func botVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" && len(setting.Value) >= 7 {
+				return setting.Value[:7]
+			}
+		}
+	}
+	return "dev"
+}
+
+// formatUptime prints duration with two largest units
+func formatUptime(duration time.Duration) string {
+	duration = duration.Round(time.Second)
+	days := int(duration.Hours()) / 24
+	hours := int(duration.Hours()) % 24
+	minutes := int(duration.Minutes()) % 60
+	seconds := int(duration.Seconds()) % 60
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%d д %d ч", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%d ч %d мин", hours, minutes)
+	case minutes > 0:
+		return fmt.Sprintf("%d мин %d с", minutes, seconds)
+	default:
+		return fmt.Sprintf("%d с", seconds)
+	}
+}
+
+// processStatus describes one managed process in a line
+func processStatus(server *StreamServer, process Process) string {
+	status := "  " + process.Name + ": "
+
+	inspector, ok := server.supervisor.(ServiceInspector)
+	if !ok {
+		running, err := server.supervisor.IsRunning(process)
+		switch {
+		case err != nil:
+			return status + "состояние неизвестно\n"
+		case running:
+			return status + "работает\n"
+		default:
+			return status + "остановлен\n"
+		}
+	}
+
+	info, err := inspector.ServiceInfo(process)
+	if err != nil {
+		server.log.Warn("failed to query service", "process", process.Name, "err", err)
+		return status + "состояние неизвестно\n"
+	}
+
+	status += info.State
+	if info.SubState != "" {
+		status += " (" + info.SubState + ")"
+	}
+	if info.PID > 0 {
+		status += fmt.Sprintf(", PID %d", info.PID)
+	}
+	if info.State == "active" || info.State == "running" {
+		if !info.Since.IsZero() {
+			status += ", работает " + formatUptime(time.Since(info.Since))
+		}
+	}
+	if info.Restarts >= 0 {
+		status += fmt.Sprintf(", перезапусков %d", info.Restarts)
+	}
+	return status + "\n"
+}
+
+// serverStatus describes state of one server for /status
+func serverStatus(server *StreamServer) string {
+	status := server.Name + " (" + server.Address + "): "
+	if server.awake() {
+		status += "система запущена\n"
+	} else {
+		status += "система остановлена\n"
+	}
+
+	status += processStatus(server, server.streamProcess)
+	status += processStatus(server, server.chatbotProcess)
+
+	if !server.awake() {
+		return status
+	}
+
+	status += "  Запросы к StreamServer: " + server.breaker.describe() + "\n"
+	started := time.Now()
+	cam, err := server.getActive()
+	if err != nil {
+		return status + "  " + serverError(err) + "\n"
+	}
+	status += fmt.Sprintf("  StreamServer отвечает за %d мс\n", time.Since(started).Milliseconds())
+
+	if cams, err := server.getCameras(); err == nil {
+		status += fmt.Sprintf("  Камер: %d\n", len(cams))
+	}
+	if cam.Name != "" {
+		status += "  Активная камера: " + cam.Name + "\n"
+	} else {
+		status += "  Активная камера: нет\n"
+	}
+	if streamURL := server.getStreamURL(); streamURL != "" {
+		status += "  Трансляция: " + streamURL + "\n"
+	}
+	if server.recorder.IsRunning() {
+		status += "  Идет запись\n"
+	} else {
+		status += "  Запись остановлена\n"
+	}
+	return status
+}
+
+// recordDiskPath is the path whose disk usage /status and the monitor report: RECORD_DIR,
+// or the working directory until the first recording creates it
+func recordDiskPath() string {
+	if _, err := os.Stat(recordDir); err != nil {
+		return "."
+	}
+	return recordDir
+}
+
+// handleStatus processes /status command, it reports the bot, its host and all servers
+func handleStatus(bot Messenger, message *tgbotapi.Message) {
+	reply := "Бот: версия " + botVersion() + ", работает " + formatUptime(time.Since(startTime)) + "\n\n"
+
+	hostname, _ := os.Hostname()
+	reply += "Хост бота " + hostname + ":\n" + describeHost(collectHostMetrics(recordDiskPath())) + "\n"
+
+	reply += "Серверы:\n"
+	for _, server := range servers {
+		reply += serverStatus(server) + "\n"
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, lastRunes(reply, maxMessageLength))
+	bot.Send(msg)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// stateFile is a path to the file with saved bot state, can be set with STATE_FILE
+var stateFile = "state.json"
+
+// stateSaveInterval is how often the state is saved without requests
+const stateSaveInterval = 30 * time.Second
+
+var (
+	// saveMu serializes saves, so an older snapshot never overwrites a newer one
+	saveMu sync.Mutex
+	// saveRequests wakes up the state saver, a pending request covers the later ones
+	saveRequests = make(chan struct{}, 1)
+)
+
+// storedServer is a saved state of one StreamServer
+type storedServer struct {
+	Awake    bool                     `json:"awake"`
+	Chatbot  bool                     `json:"chatbot"`
+	Cameras  []CameraData             `json:"cameras"`
+	Sources  map[string]AddCameraData `json:"sources"`
+	Metadata map[string]*CameraMeta   `json:"metadata,omitempty"`
+}
+
+// storedState is a snapshot of the bot state kept between restarts
+type storedState struct {
+	Servers  map[string]storedServer `json:"servers"`
+	Sessions map[int64]*Session      `json:"sessions"`
+	Settings map[int]*UserSettings   `json:"settings"`
+}
+
+// saveState writes current bot state to stateFile
+func saveState() error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	state := storedState{
+		Servers:  map[string]storedServer{},
+		Sessions: map[int64]*Session{},
+		Settings: map[int]*UserSettings{}}
+
+	for _, server := range servers {
+		state.Servers[server.Name] = server.stored()
+	}
+
+	sessionsMu.Lock()
+	chats := map[int64]*Session{}
+	for chatID, session := range sessions {
+		chats[chatID] = session
+	}
+	for userID, userSettings := range settings {
+		copied := *userSettings
+		state.Settings[userID] = &copied
+	}
+	sessionsMu.Unlock()
+
+	// sessions are locked one by one, so a busy chat doesn't block the map
+	for chatID, session := range chats {
+		state.Sessions[chatID] = session.stored()
+	}
+
+	payload, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := stateFile + ".tmp"
+	if err := os.WriteFile(tmp, payload, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, stateFile)
+}
+
+// requestSave asks the state saver to save the state, it never blocks
+func requestSave() {
+	select {
+	case saveRequests <- struct{}{}:
+	default:
+	}
+}
+
+// runStateSaver saves the state on requests and every stateSaveInterval until ctx is done.
+// Sessions are locked while the snapshot is taken, so the update loop and handlers don't save themselves.
+func runStateSaver(ctx context.Context) {
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-saveRequests:
+		case <-ticker.C:
+		}
+		if err := saveState(); err != nil {
+			botLog.Error("failed to save state", "err", err)
+		}
+	}
+}
+
+// stored returns copy of the server state for saving
+func (s *StreamServer) stored() storedServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sources := map[string]AddCameraData{}
+	for name, source := range s.sources {
+		sources[name] = source
+	}
+	metadata := map[string]*CameraMeta{}
+	for name, meta := range s.metadata {
+		metadata[name] = meta.clone()
+	}
+	return storedServer{
+		Awake:    s.isAwake,
+		Chatbot:  s.chatbotWanted,
+		Cameras:  append([]CameraData{}, s.cameras...),
+		Sources:  sources,
+		Metadata: metadata}
+}
+
+// loadState reads state saved by the previous run, missing file means clean start
+func loadState() (storedState, error) {
+	state := storedState{
+		Servers:  map[string]storedServer{},
+		Sessions: map[int64]*Session{},
+		Settings: map[int]*UserSettings{}}
+
+	payload, err := os.ReadFile(stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	// decoding goes on after a type error, so the maps are fixed even then
+	// and the caller may use the rest of the state
+	err = json.Unmarshal(payload, &state)
+	if state.Servers == nil {
+		state.Servers = map[string]storedServer{}
+	}
+	if state.Sessions == nil {
+		state.Sessions = map[int64]*Session{}
+	}
+	if state.Settings == nil {
+		state.Settings = map[int]*UserSettings{}
+	}
+	for chatID, session := range state.Sessions {
+		if session == nil {
+			delete(state.Sessions, chatID)
+		}
+	}
+	for userID, settings := range state.Settings {
+		if settings == nil {
+			delete(state.Settings, userID)
+		}
+	}
+	return state, err
+}
+
+// reconcileServer restores saved state of the server and corrects it according to
+// the running processes and StreamServer answers. Returns report for admins.
+func reconcileServer(server *StreamServer, saved storedServer) string {
+	server.setCameras(saved.Cameras)
+	server.mu.Lock()
+	if saved.Sources != nil {
+		server.sources = saved.Sources
+	}
+	if saved.Metadata != nil {
+		server.metadata = saved.Metadata
+	}
+	server.mu.Unlock()
+
+	serverRunning, err := server.supervisor.IsRunning(server.streamProcess)
+	if err != nil {
+		server.log.Warn("failed to check StreamServer process", "err", err)
+	}
+	chatbotRunning, err := server.supervisor.IsRunning(server.chatbotProcess)
+	if err != nil {
+		server.log.Warn("failed to check chatbot process", "err", err)
+	}
+
+	cam, activeErr := server.getActive()
+	// StreamServer answering in unknown format is running anyway
+	var schemaErr *schemaError
+	reachable := activeErr == nil || errors.As(activeErr, &schemaErr)
+
+	server.log.Info("reconciling state",
+		"saved_awake", saved.Awake,
+		"server_running", serverRunning,
+		"chatbot_running", chatbotRunning,
+		"reachable", reachable)
+
+	awake := serverRunning || reachable
+	server.setAwake(awake)
+	server.setChatbotWanted(awake && chatbotRunning)
+
+	message := "Сервер " + server.Name + ":\n"
+
+	switch {
+	case reachable:
+		message += "StreamServer: работает\n"
+	case serverRunning:
+		message += "StreamServer: процесс запущен, но не отвечает\n"
+	default:
+		message += "StreamServer: остановлен\n"
+	}
+
+	switch {
+	case chatbotRunning:
+		message += "Чат-бот: работает\n"
+	case saved.Chatbot && awake:
+		message += "Чат-бот: остановлен, хотя до перезапуска бота работал. Выполните /chatbot start для запуска.\n"
+	default:
+		message += "Чат-бот: остановлен\n"
+	}
+
+	if activeErr != nil && reachable {
+		message += serverError(activeErr) + "\n"
+	} else if reachable {
+		if cam.Name != "" {
+			message += "Активная камера: " + cam.Name + "\n"
+		} else {
+			message += "Активная камера: нет\n"
+		}
+	}
+
+	if !awake {
+		server.setCameras(nil)
+	}
+
+	if saved.Awake && !awake {
+		message += "Внимание: до перезапуска бота система была запущена, но сейчас StreamServer не работает. Выполните /awake для запуска.\n"
+	}
+	if !saved.Awake && awake {
+		message += "Система уже была запущена вне бота, управление восстановлено.\n"
+	}
+
+	return message
+}
+
+// reconcileState restores saved state of the bot and all servers. Returns report for admins.
+func reconcileState(saved storedState) string {
+	sessions = saved.Sessions
+	settings = saved.Settings
+
+	message := "Бот запущен. Состояние системы:\n"
+	for _, server := range servers {
+		message += "\n" + reconcileServer(server, saved.Servers[server.Name])
+	}
+
+	// Dialogs can't be continued while the system is down
+	for _, session := range sessions {
+		if !sessionServer(session).awake() {
+			session.State = StateWork
+			session.PendingCamera = ""
+			session.PendingImport = nil
+		}
+	}
+
+	return message
+}
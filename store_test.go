@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStateSaver(t *testing.T) {
+	b := newTestBot(t)
+	session := getSession(testChat)
+
+	// a chat busy with its update doesn't stall the callers
+	session.mu.Lock()
+	for i := 0; i < 3; i++ {
+		requestSave()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		runStateSaver(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(stateFile); err == nil {
+		t.Fatal("state is saved while the session is locked")
+	}
+	session.mu.Unlock()
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(stateFile); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("state is not saved")
+		}
+	}
+
+	saved, err := loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := saved.Sessions[testChat]; !ok {
+		t.Errorf("saved state %+v has no session of the chat", saved)
+	}
+	if _, ok := saved.Servers[b.server.Name]; !ok {
+		t.Errorf("saved state %+v has no server", saved)
+	}
+}
+
+func TestLoadStateTypeError(t *testing.T) {
+	newTestBot(t)
+	payload := `{"sessions": null, "settings": {"1": null}, "servers": {"test": {"awake": "yes"}}}`
+	if err := os.WriteFile(stateFile, []byte(payload), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := loadState()
+	if err == nil {
+		t.Fatal("mistyped state is loaded without error")
+	}
+	if saved.Sessions == nil || saved.Settings == nil || saved.Servers == nil {
+		t.Fatalf("state %+v has nil maps", saved)
+	}
+	if len(saved.Settings) != 0 {
+		t.Errorf("settings %v keep null entry", saved.Settings)
+	}
+
+	// main goes on with the state after logging the error
+	reconcileState(saved)
+	getSession(testChat).State = StateWork
+	getSettings(1)
+}
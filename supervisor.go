@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// readyTimeout limits waiting for StreamServer to accept connections
+	readyTimeout = 30 * time.Second
+	// defaultLogLines is the number of log lines shown by /logs
+	defaultLogLines = 20
+	maxLogLines     = 100
+	// maxMessageLength keeps text below Telegram limit of 4096 characters with room for a header
+	maxMessageLength = 3800
+)
+
+// names of the managed processes
+const (
+	streamProcessName  = "StreamServer"
+	chatbotProcessName = "chatbot"
+)
+
+// Process is a program managed by a supervisor
+type Process struct {
+	// Name is a short name used in log file names and messages
+	Name   string
+	Binary string
+	// Unit is a systemd unit name used by the systemd backend
+	Unit string
+}
+
+// Supervisor starts, stops and watches managed processes of one server
+type Supervisor interface {
+	Start(process Process) error
+	Stop(process Process) error
+	IsRunning(process Process) (bool, error)
+	// Logs returns the last lines of the process output
+	Logs(process Process, lines int) (string, error)
+}
+
+// ServiceInfo describes a process managed by a service manager
+type ServiceInfo struct {
+	Unit     string
+	State    string
+	SubState string
+	// Since is the time of the last state change
+	Since time.Time
+	PID   int
+	// Restarts is the number of automatic restarts, -1 when the backend doesn't restart processes
+	Restarts int
+}
+
+// ServiceInspector is implemented by supervisors that know more than whether a process runs
+type ServiceInspector interface {
+	ServiceInfo(process Process) (ServiceInfo, error)
+}
+
+// ExitReporter is implemented by supervisors that remember how a stopped process exited
+type ExitReporter interface {
+	// LastExit describes the last exit of the process, e.g. "exit status 1"
+	LastExit(process Process) (string, bool)
+}
+
+// newSupervisor creates backend selected in the server configuration
+func newSupervisor(config ServerConfig) (Supervisor, error) {
+	switch config.Backend {
+	case "", "local":
+		logDir := config.LogDir
+		if logDir == "" {
+			logDir = "logs"
+		}
+		return newLocalSupervisor(config.Name, logDir), nil
+	case "ssh":
+		return newSSHSupervisor(config)
+	case "systemd":
+		if config.ServerUnit == "" || config.ChatbotUnit == "" {
+			return nil, errors.New("systemd backend requires server_unit and chatbot_unit")
+		}
+		return systemdSupervisor{}, nil
+	case "docker":
+		return newDockerSupervisor(config)
+	default:
+		return nil, fmt.Errorf("unknown backend %q", config.Backend)
+	}
+}
+
+// localSupervisor runs processes on the same machine as the bot
+type localSupervisor struct {
+	server string
+	logDir string
+
+	// exits keeps the last exit status of processes started by this bot
+	mutex sync.Mutex
+	exits map[string]string
+}
+
+func newLocalSupervisor(server string, logDir string) *localSupervisor {
+	return &localSupervisor{
+		server: server,
+		logDir: logDir,
+		exits:  map[string]string{}}
+}
+
+func (l *localSupervisor) logPath(process Process) string {
+	return filepath.Join(l.logDir, l.server+"-"+process.Name+".log")
+}
+
+func (l *localSupervisor) Start(process Process) error {
+	if err := os.MkdirAll(l.logDir, 0755); err != nil {
+		return err
+	}
+	output, err := os.OpenFile(l.logPath(process), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(process.Binary)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Start(); err != nil {
+		output.Close()
+		return err
+	}
+
+	l.mutex.Lock()
+	delete(l.exits, process.Name)
+	l.mutex.Unlock()
+
+	go func() {
+		cmd.Wait()
+		status := cmd.ProcessState.String()
+		fmt.Fprintf(output, "%s: %s\n", process.Name, status)
+		output.Close()
+
+		l.mutex.Lock()
+		l.exits[process.Name] = status
+		l.mutex.Unlock()
+	}()
+	return nil
+}
+
+// ServiceInfo finds the newest matching process and its running time
+func (l *localSupervisor) ServiceInfo(process Process) (ServiceInfo, error) {
+	output, err := exec.Command("pgrep", "-n", "-f", process.Binary).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return ServiceInfo{State: "inactive", Restarts: -1}, nil
+	}
+	if err != nil {
+		return ServiceInfo{}, err
+	}
+	pid := strings.TrimSpace(string(output))
+	elapsed, err := exec.Command("ps", "-o", "etimes=", "-p", pid).Output()
+	if err != nil {
+		return ServiceInfo{}, err
+	}
+	return parseProcessInfo(pid, string(elapsed))
+}
+
+// parseProcessInfo makes ServiceInfo of running process from pgrep and "ps -o etimes=" output
+func parseProcessInfo(pid string, elapsed string) (ServiceInfo, error) {
+	info := ServiceInfo{State: "active", Restarts: -1}
+
+	var err error
+	if info.PID, err = strconv.Atoi(strings.TrimSpace(pid)); err != nil {
+		return ServiceInfo{}, fmt.Errorf("unexpected pgrep output %q", pid)
+	}
+	seconds, err := strconv.Atoi(strings.TrimSpace(elapsed))
+	if err != nil {
+		return ServiceInfo{}, fmt.Errorf("unexpected ps output %q", elapsed)
+	}
+	info.Since = time.Now().Add(-time.Duration(seconds) * time.Second)
+	return info, nil
+}
+
+func (l *localSupervisor) LastExit(process Process) (string, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	status, ok := l.exits[process.Name]
+	return status, ok
+}
+
+// Stop kills the binary, killall exits with 1 when it is already stopped
+func (l *localSupervisor) Stop(process Process) error {
+	err := exec.Command("killall", process.Binary).Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return nil
+	}
+	return err
+}
+
+func (l *localSupervisor) IsRunning(process Process) (bool, error) {
+	err := exec.Command("pgrep", "-f", process.Binary).Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *localSupervisor) Logs(process Process, lines int) (string, error) {
+	var output bytes.Buffer
+	cmd := exec.Command("tail", "-n", strconv.Itoa(lines), l.logPath(process))
+	cmd.Stdout = &output
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	return output.String(), nil
+}
+
+// waitReady waits until StreamServer accepts connections on its address
+func (s *StreamServer) waitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", s.Address, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("StreamServer is not ready after %s: %v", timeout, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// handleLogs processes /logs [streamserver|chatbot] [lines] command on the server
+func handleLogs(bot Messenger, message *tgbotapi.Message, server *StreamServer) {
+	process := server.streamProcess
+	lines := defaultLogLines
+
+	for _, field := range strings.Fields(message.CommandArguments()) {
+		if value, err := strconv.Atoi(field); err == nil && value > 0 {
+			lines = min(value, maxLogLines)
+			continue
+		}
+		switch strings.ToLower(field) {
+		case "chatbot":
+			process = server.chatbotProcess
+		case "streamserver", "server":
+			process = server.streamProcess
+		}
+	}
+
+	sendLogs(bot, message.Chat.ID, server, process, lines)
+}
+
+// sendLogs sends the last lines of the process output
+func sendLogs(bot Messenger, chatID int64, server *StreamServer, process Process, lines int) {
+	output, err := server.supervisor.Logs(process, lines)
+	if err != nil {
+		server.log.Warn("failed to read logs", "process", process.Name, "err", err)
+		msg := tgbotapi.NewMessage(chatID, "Не удалось прочитать журнал "+process.Name+": "+redact(err.Error()))
+		bot.Send(msg)
+		return
+	}
+	if strings.TrimSpace(output) == "" {
+		output = "журнал пуст"
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "Журнал "+process.Name+" на сервере "+server.Name+":\n"+lastRunes(redact(output), maxMessageLength))
+	bot.Send(msg)
+}
+
+// serviceLogLines is the number of log lines shown for each process by /services
+const serviceLogLines = 5
+
+// describeService reports state, restart count and recent log lines of the process
+func describeService(server *StreamServer, process Process) string {
+	report := process.Name + ": "
+
+	if inspector, ok := server.supervisor.(ServiceInspector); ok {
+		info, err := inspector.ServiceInfo(process)
+		if err != nil {
+			server.log.Warn("failed to query service", "process", process.Name, "err", err)
+			return report + "не удалось получить состояние: " + redact(err.Error()) + "\n"
+		}
+		report += info.State
+		if info.SubState != "" {
+			report += " (" + info.SubState + ")"
+		}
+		if info.Unit != "" {
+			report += ", юнит " + info.Unit
+		}
+		report += "\n"
+		if info.PID > 0 {
+			report += "  PID: " + strconv.Itoa(info.PID) + "\n"
+		}
+		if !info.Since.IsZero() {
+			report += "  С " + info.Since.Format("02.01.2006 15:04:05") + "\n"
+		}
+		if info.Restarts >= 0 {
+			report += "  Перезапусков: " + strconv.Itoa(info.Restarts) + "\n"
+		}
+	} else {
+		running, err := server.supervisor.IsRunning(process)
+		switch {
+		case err != nil:
+			server.log.Warn("failed to check process", "process", process.Name, "err", err)
+			report += "не удалось проверить: " + redact(err.Error()) + "\n"
+		case running:
+			report += "работает\n"
+		default:
+			report += "остановлен\n"
+		}
+	}
+
+	output, err := server.supervisor.Logs(process, serviceLogLines)
+	if err == nil && strings.TrimSpace(output) != "" {
+		report += "  Последние строки журнала:\n" + redact(output)
+	}
+	return report
+}
+
+// processHealth reports state of the processes after /awake and /halt,
+// it is empty for supervisors that don't know more than whether a process runs
+func processHealth(server *StreamServer) string {
+	inspector, ok := server.supervisor.(ServiceInspector)
+	if !ok {
+		return ""
+	}
+
+	report := ""
+	for _, process := range []Process{server.streamProcess, server.chatbotProcess} {
+		info, err := inspector.ServiceInfo(process)
+		if err != nil {
+			server.log.Warn("failed to query service", "process", process.Name, "err", err)
+			report += "\n" + process.Name + ": состояние неизвестно"
+			continue
+		}
+		report += "\n" + process.Name + ": " + info.State
+		if info.SubState != "" {
+			report += " (" + info.SubState + ")"
+		}
+	}
+	return report
+}
+
+// handleServices processes /services command, it reports managed processes of the server
+func handleServices(bot Messenger, message *tgbotapi.Message, server *StreamServer) {
+	backend := server.Backend
+	if backend == "" {
+		backend = "local"
+	}
+
+	reply := "Сервисы сервера " + server.Name + " (" + backend + "):\n\n"
+	reply += describeService(server, server.streamProcess) + "\n"
+	reply += describeService(server, server.chatbotProcess)
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, lastRunes(reply, maxMessageLength))
+	bot.Send(msg)
+}
+
+// lastRunes cuts the beginning of the text longer than limit
+func lastRunes(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return "..." + string(runes[len(runes)-limit:])
+}
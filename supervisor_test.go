@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalSupervisorRecordsExit(t *testing.T) {
+	supervisor := newLocalSupervisor("test", t.TempDir())
+	process := Process{Name: chatbotProcessName, Binary: "false"}
+
+	if err := supervisor.Start(process); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if status, ok := supervisor.LastExit(process); ok {
+			if !strings.Contains(status, "exit status 1") {
+				t.Errorf("LastExit = %q, want exit status 1", status)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("exit of the process was not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestParseProcessInfo(t *testing.T) {
+	info, err := parseProcessInfo("1234\n", "  90\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PID != 1234 || info.State != "active" || info.Restarts != -1 {
+		t.Errorf("unexpected info %+v", info)
+	}
+	if elapsed := time.Since(info.Since); elapsed < 89*time.Second || elapsed > 92*time.Second {
+		t.Errorf("Since is %s ago, want 90s", elapsed)
+	}
+
+	if _, err := parseProcessInfo("abc", "1"); err == nil {
+		t.Error("expected error for bad pid")
+	}
+}
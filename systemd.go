@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+// systemdTimeout limits one D-Bus call including waiting for a unit job
+const systemdTimeout = 30 * time.Second
+
+// systemdSupervisor manages processes as systemd units of the local machine.
+// Units must be installed beforehand, the bot only starts and stops them.
+type systemdSupervisor struct{}
+
+// connect opens connection to the system bus
+func (systemdSupervisor) connect() (*dbus.Conn, context.Context, context.CancelFunc, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), systemdTimeout)
+	conn, err := dbus.NewWithContext(ctx)
+	if err != nil {
+		cancel()
+		return nil, nil, nil, err
+	}
+	return conn, ctx, cancel, nil
+}
+
+// runJob starts unit job and waits for its result
+func (s systemdSupervisor) runJob(unit string, job func(*dbus.Conn, context.Context, chan<- string) (int, error)) error {
+	conn, ctx, cancel, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer conn.Close()
+
+	result := make(chan string, 1)
+	if _, err := job(conn, ctx, result); err != nil {
+		return err
+	}
+
+	select {
+	case status := <-result:
+		if status != "done" {
+			return fmt.Errorf("job for %s finished with %q", unit, status)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("job for %s did not finish in time", unit)
+	}
+}
+
+func (s systemdSupervisor) Start(process Process) error {
+	return s.runJob(process.Unit, func(conn *dbus.Conn, ctx context.Context, result chan<- string) (int, error) {
+		return conn.StartUnitContext(ctx, process.Unit, "replace", result)
+	})
+}
+
+func (s systemdSupervisor) Stop(process Process) error {
+	return s.runJob(process.Unit, func(conn *dbus.Conn, ctx context.Context, result chan<- string) (int, error) {
+		return conn.StopUnitContext(ctx, process.Unit, "replace", result)
+	})
+}
+
+func (s systemdSupervisor) IsRunning(process Process) (bool, error) {
+	info, err := s.ServiceInfo(process)
+	if err != nil {
+		return false, err
+	}
+	return info.State == "active", nil
+}
+
+func (systemdSupervisor) Logs(process Process, lines int) (string, error) {
+	var output bytes.Buffer
+	cmd := exec.Command("journalctl", "--unit", process.Unit, "--lines", strconv.Itoa(lines), "--no-pager", "--output", "short-iso")
+	cmd.Stdout = &output
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	return output.String(), nil
+}
+
+// ServiceInfo reads unit state, main PID and restart counter
+func (s systemdSupervisor) ServiceInfo(process Process) (ServiceInfo, error) {
+	conn, ctx, cancel, err := s.connect()
+	if err != nil {
+		return ServiceInfo{}, err
+	}
+	defer cancel()
+	defer conn.Close()
+
+	unitProperties, err := conn.GetUnitPropertiesContext(ctx, process.Unit)
+	if err != nil {
+		return ServiceInfo{}, err
+	}
+	serviceProperties, err := conn.GetUnitTypePropertiesContext(ctx, process.Unit, "Service")
+	if err != nil {
+		return ServiceInfo{}, err
+	}
+
+	info := ServiceInfo{Unit: process.Unit}
+	info.State, _ = unitProperties["ActiveState"].(string)
+	info.SubState, _ = unitProperties["SubState"].(string)
+	// timestamps are reported in microseconds since epoch, zero when the unit never entered the state
+	if since, ok := unitProperties["StateChangeTimestamp"].(uint64); ok && since > 0 {
+		info.Since = time.UnixMicro(int64(since))
+	}
+	if pid, ok := serviceProperties["MainPID"].(uint32); ok {
+		info.PID = int(pid)
+	}
+	if restarts, ok := serviceProperties["NRestarts"].(uint32); ok {
+		info.Restarts = int(restarts)
+	}
+	return info, nil
+}
+
+// LastExit reports status of the last exited main process of the unit
+func (s systemdSupervisor) LastExit(process Process) (string, bool) {
+	conn, ctx, cancel, err := s.connect()
+	if err != nil {
+		return "", false
+	}
+	defer cancel()
+	defer conn.Close()
+
+	properties, err := conn.GetUnitTypePropertiesContext(ctx, process.Unit, "Service")
+	if err != nil {
+		return "", false
+	}
+	// zero timestamp means the main process never exited
+	if exited, ok := properties["ExecMainExitTimestamp"].(uint64); !ok || exited == 0 {
+		return "", false
+	}
+	status, ok := properties["ExecMainStatus"].(int32)
+	if !ok {
+		return "", false
+	}
+	return "exit status " + strconv.Itoa(int(status)), true
+}
+
+// notifySystemdReady reports readiness to systemd and keeps its watchdog fed until ctx is done.
+// Without NOTIFY_SOCKET, when the bot isn't run by systemd, it does nothing.
+func notifySystemdReady(ctx context.Context) {
+	sent, err := daemon.SdNotify(false, daemon.SdNotifyReady)
+	if err != nil {
+		systemLog.Warn("failed to notify systemd", "err", err)
+		return
+	}
+	if !sent {
+		return
+	}
+
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		systemLog.Warn("invalid systemd watchdog configuration", "err", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+
+	systemLog.Info("systemd watchdog enabled", "interval", interval)
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		stalled := false
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// a stuck update loop stops the pings, so systemd restarts the bot
+				age := time.Since(time.Unix(0, updateLoopAlive.Load()))
+				if age > interval/2+heartbeatInterval {
+					if !stalled {
+						systemLog.Warn("update loop is not responding, watchdog is not fed", "since", age)
+					}
+					stalled = true
+					continue
+				}
+				if stalled {
+					systemLog.Info("update loop is responding again")
+				}
+				stalled = false
+				daemon.SdNotify(false, daemon.SdNotifyWatchdog)
+			}
+		}
+	}()
+}
+
+// updateLoopAlive is the time in nanoseconds when runUpdates last reported it is alive
+var updateLoopAlive atomic.Int64
+
+// markUpdateLoopAlive is called by runUpdates on every pass of its loop
+func markUpdateLoopAlive() {
+	updateLoopAlive.Store(time.Now().UnixNano())
+}
+
+// notifySystemdStopping tells systemd that the bot is shutting down
+func notifySystemdStopping() {
+	daemon.SdNotify(false, daemon.SdNotifyStopping)
+}
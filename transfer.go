@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// configVersion is the version of the /export document format
+	configVersion = 1
+	// maxImportSize limits the size of the file accepted by /import
+	maxImportSize = 1 << 20
+)
+
+// Callback data of import confirmation buttons.
+const (
+	callbackApplyImport  = "import:apply"
+	callbackCancelImport = "import:cancel"
+)
+
+// exportedCamera is a camera in the /export document
+type exportedCamera struct {
+	Name        string   `json:"name" yaml:"name"`
+	Type        int      `json:"type" yaml:"type"`
+	URL         string   `json:"url,omitempty" yaml:"url,omitempty"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Location    string   `json:"location,omitempty" yaml:"location,omitempty"`
+	Tags        []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Owner       string   `json:"owner,omitempty" yaml:"owner,omitempty"`
+}
+
+// configDocument is the camera configuration of a server moved between setups with /export and /import.
+// Presets are built into the bot, they are exported for reference and not imported.
+type configDocument struct {
+	Version  int              `json:"version" yaml:"version"`
+	Server   string           `json:"server" yaml:"server"`
+	Exported time.Time        `json:"exported" yaml:"exported"`
+	Cameras  []exportedCamera `json:"cameras" yaml:"cameras"`
+	Presets  []exportedCamera `json:"presets,omitempty" yaml:"presets,omitempty"`
+}
+
+// importChange is a difference between a camera of the document and the live one
+type importChange struct {
+	Camera exportedCamera
+	// Action is "add", "update", "skip" or "same"
+	Action string
+	// Details lists changed fields or the reason to skip the camera
+	Details []string
+}
+
+// exportConfig collects cameras of the server with their metadata
+func exportConfig(server *StreamServer) (configDocument, error) {
+	cameras := server.cachedCameras()
+	if server.awake() {
+		live, err := server.getCameras()
+		if err != nil {
+			return configDocument{}, err
+		}
+		server.setCameras(live)
+		cameras = live
+	}
+
+	document := configDocument{
+		Version:  configVersion,
+		Server:   server.Name,
+		Exported: time.Now().UTC().Truncate(time.Second),
+		Cameras:  []exportedCamera{}}
+
+	for _, camera := range cameras {
+		item := exportedCamera{Name: camera.Name, Type: camera.Type}
+		if source, ok := server.cameraSource(camera.Name); ok {
+			item.URL = source.URL
+		}
+		if meta := server.cameraMeta(camera.Name); meta != nil {
+			item.Description = meta.Description
+			item.Location = meta.Location
+			item.Tags = meta.Tags
+			item.Owner = meta.Owner
+		}
+		document.Cameras = append(document.Cameras, item)
+	}
+	for _, preset := range presets {
+		document.Presets = append(document.Presets, exportedCamera{Name: preset.Name, Type: preset.Type, URL: preset.URL})
+	}
+	return document, nil
+}
+
+// parseConfig reads /export document in JSON or YAML, the format is chosen by the file name or content
+func parseConfig(name string, payload []byte) (configDocument, error) {
+	var document configDocument
+	extension := strings.ToLower(filepath.Ext(name))
+	isJSON := extension == ".json" || (extension != ".yaml" && extension != ".yml" && bytes.HasPrefix(bytes.TrimSpace(payload), []byte("{")))
+
+	if isJSON {
+		if err := json.Unmarshal(payload, &document); err != nil {
+			return document, errors.New(describeJSONError(err))
+		}
+	} else if err := yaml.Unmarshal(payload, &document); err != nil {
+		return document, err
+	}
+
+	if document.Version != configVersion {
+		return document, fmt.Errorf("unsupported version %d, expected %d", document.Version, configVersion)
+	}
+	seen := map[string]bool{}
+	for i, camera := range document.Cameras {
+		switch {
+		case camera.Name == "":
+			return document, fmt.Errorf("camera %d has no name", i+1)
+		case camera.Type < 0 || camera.Type > 2:
+			return document, fmt.Errorf("camera %q has unknown type %d", camera.Name, camera.Type)
+		case seen[camera.Name]:
+			return document, fmt.Errorf("camera %q is listed twice", camera.Name)
+		}
+		seen[camera.Name] = true
+		document.Cameras[i].Tags = parseTags(strings.Join(camera.Tags, ","))
+	}
+	return document, nil
+}
+
+// planImport compares cameras of the document with the live cameras of the server.
+// StreamServer can't change a camera, so cameras with another type or connection string are skipped.
+// Returns the changes and the cameras found only on the server.
+func planImport(server *StreamServer, document configDocument) ([]importChange, []string, error) {
+	live, err := server.getCameras()
+	if err != nil {
+		return nil, nil, err
+	}
+	server.setCameras(live)
+
+	liveTypes := map[string]int{}
+	for _, camera := range live {
+		liveTypes[camera.Name] = camera.Type
+	}
+
+	var changes []importChange
+	for _, camera := range document.Cameras {
+		change := importChange{Camera: camera}
+		liveType, exists := liveTypes[camera.Name]
+
+		switch {
+		case !exists && camera.URL == "":
+			change.Action = "skip"
+			change.Details = []string{"нет строки подключения"}
+
+		case !exists:
+			change.Action = "add"
+
+		case liveType != camera.Type:
+			change.Action = "skip"
+			change.Details = []string{fmt.Sprintf("на сервере тип %d, в файле %d", liveType, camera.Type)}
+
+		default:
+			server.mu.Lock()
+			source, known := server.sources[camera.Name]
+			server.mu.Unlock()
+			if known && camera.URL != "" && source.URL != camera.URL {
+				change.Action = "skip"
+				change.Details = []string{"на сервере другая строка подключения"}
+				break
+			}
+			if !known && camera.URL != "" {
+				change.Details = append(change.Details, "строка подключения")
+			}
+
+			meta := server.cameraMeta(camera.Name)
+			if meta == nil {
+				meta = &CameraMeta{}
+			}
+			if camera.Description != "" && camera.Description != meta.Description {
+				change.Details = append(change.Details, "описание")
+			}
+			if camera.Location != "" && camera.Location != meta.Location {
+				change.Details = append(change.Details, "место")
+			}
+			if camera.Owner != "" && camera.Owner != meta.Owner {
+				change.Details = append(change.Details, "ответственный")
+			}
+			if len(camera.Tags) > 0 && strings.Join(camera.Tags, ",") != strings.Join(meta.Tags, ",") {
+				change.Details = append(change.Details, "теги")
+			}
+
+			change.Action = "same"
+			if len(change.Details) > 0 {
+				change.Action = "update"
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	var extra []string
+	for _, camera := range live {
+		found := false
+		for _, item := range document.Cameras {
+			found = found || item.Name == camera.Name
+		}
+		if !found {
+			extra = append(extra, camera.Name)
+		}
+	}
+	return changes, extra, nil
+}
+
+// describePlan prints the changes for confirmation, returns false if there is nothing to apply
+func describePlan(server *StreamServer, document configDocument, changes []importChange, extra []string) (string, bool) {
+	text := "Импорт конфигурации сервера " + document.Server + " на сервер " + server.Name + ":\n"
+	same := 0
+	apply := false
+	for _, change := range changes {
+		switch change.Action {
+		case "add":
+			text += "+ " + change.Camera.Name + " - будет добавлена\n"
+			apply = true
+		case "update":
+			text += "~ " + change.Camera.Name + " - будут обновлены: " + strings.Join(change.Details, ", ") + "\n"
+			apply = true
+		case "skip":
+			text += "! " + change.Camera.Name + " - пропущена: " + strings.Join(change.Details, ", ") + "\n"
+		default:
+			same++
+		}
+	}
+	if same > 0 {
+		text += fmt.Sprintf("Без изменений: %d\n", same)
+	}
+	if len(extra) > 0 {
+		text += "Только на сервере, останутся как есть: " + strings.Join(extra, ", ") + "\n"
+	}
+	if !apply {
+		return text + "\nПрименять нечего.", false
+	}
+	return text + "\nПрименить изменения?", true
+}
+
+// applyImport adds and updates cameras of the document, each added camera is verified on StreamServer
+func applyImport(server *StreamServer, document configDocument) string {
+	changes, _, err := planImport(server, document)
+	if err != nil {
+		return serverError(err)
+	}
+
+	report := "Импорт завершен:\n"
+	for _, change := range changes {
+		camera := change.Camera
+		switch change.Action {
+		case "add":
+			err := server.addCamera(AddCameraData{Name: camera.Name, Type: camera.Type, URL: camera.URL})
+			if err != nil {
+				report += "! " + camera.Name + ": " + changeResult(err, "") + "\n"
+				continue
+			}
+			report += "+ " + camera.Name + " добавлена\n"
+		case "update":
+			if camera.URL != "" {
+				server.mu.Lock()
+				if _, known := server.sources[camera.Name]; !known {
+					server.sources[camera.Name] = AddCameraData{Name: camera.Name, Type: camera.Type, URL: camera.URL}
+				}
+				server.mu.Unlock()
+			}
+			report += "~ " + camera.Name + " обновлена\n"
+		default:
+			continue
+		}
+
+		server.updateMeta(camera.Name, func(meta *CameraMeta) {
+			if camera.Description != "" {
+				meta.Description = camera.Description
+			}
+			if camera.Location != "" {
+				meta.Location = camera.Location
+			}
+			if camera.Owner != "" {
+				meta.Owner = camera.Owner
+			}
+			if len(camera.Tags) > 0 {
+				meta.Tags = camera.Tags
+			}
+		})
+	}
+	server.log.Info("configuration imported", "from", document.Server, "cameras", len(document.Cameras))
+	return report
+}
+
+// importKeyboard returns Apply/Cancel buttons for the import
+func importKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Применить", callbackApplyImport),
+			tgbotapi.NewInlineKeyboardButtonData("Отмена", callbackCancelImport)))
+}
+
+// downloadClient fetches files sent to the bot, it uses the proxy of Telegram API
+var downloadClient = &http.Client{Timeout: 30 * time.Second}
+
+// downloadFile returns content of the file sent to the bot
+func downloadFile(bot Messenger, fileID string) ([]byte, error) {
+	url, err := bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := downloadClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed: %s", response.Status)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, maxImportSize+1))
+}
+
+// handleExport processes /export [json|yaml] command, the document is sent as a file
+func handleExport(bot Messenger, message *tgbotapi.Message, server *StreamServer) {
+	format := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	if format == "" {
+		format = "json"
+	}
+	if format == "yml" {
+		format = "yaml"
+	}
+	if format != "json" && format != "yaml" {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Простите, но такого формата нет. Используйте /export json или /export yaml.")
+		bot.Send(msg)
+		return
+	}
+
+	document, err := exportConfig(server)
+	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, serverError(err))
+		bot.Send(msg)
+		return
+	}
+
+	var payload []byte
+	if format == "json" {
+		payload, err = json.MarshalIndent(document, "", "  ")
+	} else {
+		payload, err = yaml.Marshal(document)
+	}
+	if err != nil {
+		botLog.Error("failed to encode configuration", "format", format, "err", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Не удалось сформировать файл конфигурации.")
+		bot.Send(msg)
+		return
+	}
+
+	name := "cameras-" + server.Name + "-" + time.Now().Format("20060102") + "." + format
+	upload := tgbotapi.NewDocumentUpload(message.Chat.ID, tgbotapi.FileBytes{Name: name, Bytes: payload})
+	upload.Caption = fmt.Sprintf("Камер: %d. Файл содержит строки подключения камер, не пересылайте его посторонним.", len(document.Cameras))
+	if _, err := bot.Send(upload); err != nil {
+		botLog.Error("failed to send configuration", "err", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Не удалось отправить файл конфигурации.")
+		bot.Send(msg)
+	}
+}
+
+// handleImport processes /import command, the file is expected in the next message
+func handleImport(bot Messenger, message *tgbotapi.Message, session *Session) {
+	if !sessionServer(session).awake() {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Важно - настройка камер невозможна при выключенной системе. Пожалуйста, выполните команду /awake для запуска.\n")
+		bot.Send(msg)
+		return
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, "Отправьте файл .json или .yaml, полученный командой /export. Для отмены введите /cancel.")
+	bot.Send(msg)
+	session.State = StateImportFile
+}
+
+// handleImportFile reads the file sent after /import and asks to confirm the changes
+func handleImportFile(bot Messenger, message *tgbotapi.Message, session *Session) {
+	reply := func(text string) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, text)
+		bot.Send(msg)
+	}
+	server := sessionServer(session)
+	file := message.Document
+
+	if file.FileSize > maxImportSize {
+		reply("Файл слишком большой. Отправьте файл, полученный командой /export, или введите /cancel.")
+		return
+	}
+	payload, err := downloadFile(bot, file.FileID)
+	if err != nil {
+		botLog.Error("failed to download file", "file", file.FileName, "err", err)
+		reply("Не удалось загрузить файл. Попробуйте еще раз или введите /cancel.")
+		return
+	}
+	if len(payload) > maxImportSize {
+		reply("Файл слишком большой. Отправьте файл, полученный командой /export, или введите /cancel.")
+		return
+	}
+
+	document, err := parseConfig(file.FileName, payload)
+	if err != nil {
+		reply("Файл не похож на результат /export: " + err.Error() + ". Отправьте другой файл или введите /cancel.")
+		return
+	}
+
+	changes, extra, err := planImport(server, document)
+	if err != nil {
+		reply(serverError(err))
+		session.State = StateWork
+		return
+	}
+
+	text, apply := describePlan(server, document, changes, extra)
+	msg := tgbotapi.NewMessage(message.Chat.ID, lastRunes(text, maxMessageLength))
+	if !apply {
+		bot.Send(msg)
+		session.State = StateWork
+		return
+	}
+	msg.ReplyMarkup = importKeyboard()
+	bot.Send(msg)
+	session.PendingImport = &document
+	session.State = StateConfirmImport
+}
+
+// handleImportAnswer applies the pending import after confirmation or cancels it
+func handleImportAnswer(bot Messenger, query *tgbotapi.CallbackQuery, session *Session) {
+	chatID := query.Message.Chat.ID
+
+	// Buttons are not needed anymore
+	bot.Send(tgbotapi.NewEditMessageReplyMarkup(chatID, query.Message.MessageID, tgbotapi.NewInlineKeyboardMarkup()))
+
+	if session.State != StateConfirmImport || session.PendingImport == nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, "Этот импорт уже неактуален."))
+		return
+	}
+
+	document := *session.PendingImport
+	session.PendingImport = nil
+	session.State = StateWork
+
+	if query.Data == callbackCancelImport {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, "Отменено"))
+		msg := tgbotapi.NewMessage(chatID, "Импорт отменен. Введите следующую команду.")
+		bot.Send(msg)
+		return
+	}
+
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, ""))
+	server := sessionServer(session)
+	runAsync(bot, chatID, "Применяю конфигурацию...", func() string {
+		return lastRunes(applyImport(server, document), maxMessageLength)
+	})
+}
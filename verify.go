@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+const (
+	// verifyTimeout limits waiting for StreamServer to apply a camera change
+	verifyTimeout = 10 * time.Second
+	// verifyInterval is the pause between checks of the change
+	verifyInterval = 500 * time.Millisecond
+)
+
+// waitChange polls check until it reports the change or verifyTimeout elapses.
+// check returns whether the change is seen and what the server reports now, its requests
+// must finish by the deadline it gets, so the verification doesn't outlast verifyTimeout.
+// Errors of the requests are tolerated unless calls are stopped by the circuit breaker.
+func (s *StreamServer) waitChange(change string, check func(deadline time.Time) (bool, string, error)) error {
+	deadline := time.Now().Add(verifyTimeout)
+	actual := "нет ответа"
+	for {
+		done, reported, err := check(deadline)
+		switch {
+		case err == nil && done:
+			return nil
+		case err == nil:
+			actual = reported
+		case errors.Is(err, errBreakerOpen):
+			return err
+		default:
+			s.log.Warn("failed to verify change", "change", change, "err", err)
+		}
+
+		if time.Until(deadline) < verifyInterval {
+			s.log.Error("change is not applied", "change", change, "actual", actual)
+			return &notAppliedError{Change: change, Actual: actual}
+		}
+		time.Sleep(verifyInterval)
+	}
+}
+
+// waitCameraActive waits until StreamServer reports the camera as active
+func (s *StreamServer) waitCameraActive(name string) error {
+	return s.waitChange("активна камера \""+name+"\"", func(deadline time.Time) (bool, string, error) {
+		cam, err := s.getActiveUntil(deadline)
+		if err != nil {
+			return false, "", err
+		}
+		if cam.Name == "" {
+			return false, "нет активной камеры", nil
+		}
+		return cam.Name == name, "активна камера \"" + cam.Name + "\"", nil
+	})
+}
+
+// waitCameraListed waits until StreamServer lists the camera
+func (s *StreamServer) waitCameraListed(name string) error {
+	return s.waitChange("камера \""+name+"\" в списке", func(deadline time.Time) (bool, string, error) {
+		cameras, err := s.getCamerasUntil(deadline)
+		if err != nil {
+			return false, "", err
+		}
+		for _, camera := range cameras {
+			if camera.Name == name {
+				s.setCameras(cameras)
+				return true, "", nil
+			}
+		}
+		return false, "камер в списке: " + strconv.Itoa(len(cameras)) + ", новой среди них нет", nil
+	})
+}
+
+// changeResult describes the result of a camera change for the user
+func changeResult(err error, success string) string {
+	var notApplied *notAppliedError
+	switch {
+	case err == nil:
+		return success
+	case errors.As(err, &notApplied):
+		return "StreamServer принял запрос, но изменение не подтвердилось за " + strconv.Itoa(int(verifyTimeout.Seconds())) +
+			" с: ожидалось, что " + notApplied.Change + ", а сейчас " + notApplied.Actual + ". Проверьте результат командой /getactive или /getcameras."
+	default:
+		return "Не удалось выполнить запрос. " + serverError(err)
+	}
+}
+
+// selectCameraAsync switches the camera in the background, reporting the verified result to the chat
+func selectCameraAsync(bot Messenger, chatID int64, server *StreamServer, name string) {
+	runAsync(bot, chatID, "Переключаю камеру \""+name+"\"...", func() string {
+		return changeResult(server.selectCamera(name), "Камера успешно выбрана.")
+	})
+}
+
+// addCameraAsync creates the camera in the background, reporting the verified result to the chat
+func addCameraAsync(bot Messenger, chatID int64, server *StreamServer, camera AddCameraData) {
+	runAsync(bot, chatID, "Создаю камеру \""+camera.Name+"\"...", func() string {
+		return changeResult(server.addCamera(camera), "Новая камера успешно создана. Вы можете ее увидеть в списке, введя команду /getcameras.")
+	})
+}
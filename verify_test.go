@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallUntilDeadline(t *testing.T) {
+	b := newTestBot(t)
+	b.fake.setMode("/get-active", fakeSlow)
+
+	started := time.Now()
+	if _, err := b.server.getActiveUntil(started.Add(200 * time.Millisecond)); err == nil {
+		t.Fatal("request outlived the deadline without error")
+	}
+	if elapsed := time.Since(started); elapsed > fakeDelay/2 {
+		t.Errorf("request took %v, the deadline is 200ms", elapsed)
+	}
+	// a verification poll is sent once, the next poll replaces the retry
+	b.expectCalls("GET /get-active")
+
+	if _, err := b.server.getActiveUntil(time.Now().Add(-time.Second)); err == nil {
+		t.Error("request is sent after the deadline")
+	}
+	b.expectCalls()
+}
+
+func TestCallUntilKeepsBreakerUsable(t *testing.T) {
+	b := newTestBot(t)
+	breaker := b.server.breaker
+	expire := func() {
+		breaker.mu.Lock()
+		defer breaker.mu.Unlock()
+		breaker.state = breakerOpen
+		breaker.failures = breakerThreshold
+		breaker.openedAt = time.Now().Add(-breakerCooldown - time.Second)
+	}
+
+	// a deadline already past sends nothing and takes no trial call
+	expire()
+	if _, err := b.server.getActiveUntil(time.Now().Add(-time.Second)); err == nil {
+		t.Error("request is sent after the deadline")
+	}
+	// a trial call cut by the deadline frees the slot for the next one
+	b.fake.setMode("/get-active", fakeSlow)
+	if _, err := b.server.getActiveUntil(time.Now().Add(100 * time.Millisecond)); err == nil {
+		t.Error("request outlived the deadline without error")
+	}
+	if state := breaker.describe(); state != "выполняется пробный запрос" {
+		t.Errorf("breaker is %q after the cut trial call, want half-open", state)
+	}
+	b.fake.setMode("/get-active", fakeOK)
+	if _, err := b.server.getActive(); err != nil {
+		t.Errorf("trial call after the cut one failed: %v", err)
+	}
+	if state := breaker.describe(); state != "работает" {
+		t.Errorf("breaker is %q, want closed", state)
+	}
+	b.expectCalls("GET /get-active", "GET /get-active")
+}
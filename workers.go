@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// updateWorkers is the number of updates handled at once, can be set with UPDATE_WORKERS
+var updateWorkers = 4
+
+// dispatcher handles updates of different chats concurrently on at most updateWorkers
+// workers, updates of one chat are handled one by one in the order of arrival
+type dispatcher struct {
+	bot   Messenger
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	mu sync.Mutex
+	// queues keeps updates of chats being handled, a chat without a queue is idle
+	queues map[int64][]tgbotapi.Update
+}
+
+func newDispatcher(bot Messenger, workers int) *dispatcher {
+	return &dispatcher{
+		bot:    bot,
+		slots:  make(chan struct{}, max(workers, 1)),
+		queues: map[int64][]tgbotapi.Update{}}
+}
+
+// dispatch queues the update of the chat, an idle chat gets its own goroutine
+func (d *dispatcher) dispatch(update tgbotapi.Update) {
+	chatID, _, _ := updateSource(update)
+
+	d.mu.Lock()
+	queue, busy := d.queues[chatID]
+	d.queues[chatID] = append(queue, update)
+	d.mu.Unlock()
+
+	if !busy {
+		d.wg.Add(1)
+		go d.drain(chatID)
+	}
+}
+
+// drain handles queued updates of the chat until the queue is empty
+func (d *dispatcher) drain(chatID int64) {
+	defer d.wg.Done()
+
+	for {
+		d.mu.Lock()
+		queue := d.queues[chatID]
+		if len(queue) == 0 {
+			delete(d.queues, chatID)
+			d.mu.Unlock()
+			return
+		}
+		update := queue[0]
+		d.queues[chatID] = queue[1:]
+		d.mu.Unlock()
+
+		d.slots <- struct{}{}
+		handleUpdate(d.bot, update)
+		<-d.slots
+	}
+}
+
+// wait blocks until all queued updates are handled
+func (d *dispatcher) wait() {
+	d.wg.Wait()
+}
+
+// tasks tracks long operations started with runAsync
+var tasks sync.WaitGroup
+
+// runAsync sends the progress message and runs op in the background, then the message
+// is replaced with the result of op. The handler returns at once, so the chat stays responsive.
+func runAsync(bot Messenger, chatID int64, progress string, op func() string) {
+	sent, err := bot.Send(tgbotapi.NewMessage(chatID, progress))
+	if err != nil {
+		botLog.Warn("failed to send progress message", "chat_id", chatID, "err", err)
+	}
+
+	finish := func(result string) {
+		if err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, result))
+			return
+		}
+		bot.Send(tgbotapi.NewEditMessageText(chatID, sent.MessageID, result))
+	}
+
+	tasks.Add(1)
+	go func() {
+		defer tasks.Done()
+		defer func() {
+			failure := recover()
+			if failure == nil {
+				return
+			}
+			id := newCorrelationID()
+			stack := string(debug.Stack())
+			botLog.Error("task panicked",
+				"correlation_id", id,
+				"chat_id", chatID,
+				"task", redact(progress),
+				"panic", fmt.Sprint(failure),
+				"stack", stack)
+			finish("Простите, при выполнении команды произошла внутренняя ошибка.\nКод ошибки: " + id)
+			reportCrash(bot, fmt.Sprintf("Сбой фоновой задачи, код %s\nЧат %d\n%s", id, chatID, progress), failure, stack)
+		}()
+
+		finish(op())
+		// the operation usually changes the state of the server
+		requestSave()
+	}()
+}